@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"log"
+	"os"
 
 	"github.com/namtran/creditengine/internal/engine"
 )
@@ -10,6 +11,13 @@ import (
 func main() {
 	ctx := context.Background()
 	cfg := engine.DefaultConfig()
+	if path := os.Getenv("CONFIRMATION_POLICY"); path != "" {
+		pol, err := engine.LoadConfirmationPolicy(path)
+		if err != nil {
+			log.Fatalf("failed to load confirmation policy: %v", err)
+		}
+		cfg.ConfirmationPolicy = pol
+	}
 
 	svc, err := engine.NewService(cfg)
 	if err != nil {
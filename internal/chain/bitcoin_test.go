@@ -0,0 +1,152 @@
+package chain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// regtestNode mimics the parts of a bitcoind regtest node the client uses.
+type regtestNode struct {
+	height  uint64
+	hashes  map[uint64]string
+	headers map[string]map[string]interface{}
+	txs     map[string]map[string]interface{}
+	warmup  bool
+}
+
+func newRegtestNode() *regtestNode {
+	n := &regtestNode{hashes: make(map[uint64]string), headers: make(map[string]map[string]interface{}), txs: make(map[string]map[string]interface{})}
+	n.height = 110
+	prev := ""
+	for h := uint64(100); h <= n.height; h++ {
+		hash := strings.Repeat("0", 61) + string(rune('a'+h-100)) + "00"
+		n.hashes[h] = hash
+		n.headers[hash] = map[string]interface{}{"hash": hash, "height": h, "confirmations": n.height - h + 1, "previousblockhash": prev}
+		prev = hash
+	}
+	return n
+}
+
+func (n *regtestNode) serve(t *testing.T) *BitcoinClient {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != "rpc" || p != "secret" {
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Params []interface{}   `json:"params"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		reply := func(status int, result interface{}, code int, msg string) {
+			var rpcErr interface{}
+			if code != 0 {
+				rpcErr = map[string]interface{}{"code": code, "message": msg}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": result, "error": rpcErr, "id": req.ID})
+		}
+		if n.warmup {
+			reply(http.StatusInternalServerError, nil, -28, "Loading block index...")
+			return
+		}
+		switch req.Method {
+		case "getblockcount":
+			reply(http.StatusOK, n.height, 0, "")
+		case "getblockhash":
+			h, ok := n.hashes[uint64(req.Params[0].(float64))]
+			if !ok {
+				reply(http.StatusInternalServerError, nil, -8, "Block height out of range")
+				return
+			}
+			reply(http.StatusOK, h, 0, "")
+		case "getblockheader":
+			h, ok := n.headers[req.Params[0].(string)]
+			if !ok {
+				reply(http.StatusInternalServerError, nil, -5, "Block not found")
+				return
+			}
+			reply(http.StatusOK, h, 0, "")
+		case "getrawtransaction":
+			tx, ok := n.txs[req.Params[0].(string)]
+			if !ok {
+				reply(http.StatusInternalServerError, nil, -5, "No such mempool or blockchain transaction. Use gettransaction for wallet transactions.")
+				return
+			}
+			reply(http.StatusOK, tx, 0, "")
+		default:
+			reply(http.StatusNotFound, nil, -32601, "Method not found")
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := NewBitcoin(strings.Replace(srv.URL, "http://", "http://rpc:secret@", 1))
+	if err != nil {
+		t.Fatalf("NewBitcoin: %v", err)
+	}
+	return c
+}
+
+func TestBitcoinClient_ConfirmationsFromTxHash(t *testing.T) {
+	n := newRegtestNode()
+	n.txs["mined"] = map[string]interface{}{"txid": "mined", "blockhash": n.hashes[105], "confirmations": 6, "vout": []map[string]interface{}{{"n": 0}, {"n": 1}}}
+	n.txs["mempool"] = map[string]interface{}{"txid": "mempool"}
+	n.headers["stale"] = map[string]interface{}{"hash": "stale", "height": 108, "confirmations": -1}
+	n.txs["orphaned"] = map[string]interface{}{"txid": "orphaned", "blockhash": "stale"}
+	c := n.serve(t)
+	ctx := context.Background()
+
+	txBlock, conf, blockHash, found, reverted, err := c.ConfirmationsFromTxHash(ctx, Outpoint("mined", 1))
+	if err != nil || !found || reverted {
+		t.Fatalf("mined: found=%v reverted=%v err=%v", found, reverted, err)
+	}
+	if txBlock != 105 || conf != 6 || blockHash != n.hashes[105] {
+		t.Fatalf("mined: got block %d, %d confirmations, hash %s", txBlock, conf, blockHash)
+	}
+
+	for _, txid := range []string{"mempool", "orphaned", "unknown", Outpoint("mined", 2)} {
+		if _, _, _, found, _, err := c.ConfirmationsFromTxHash(ctx, txid); err != nil || found {
+			t.Fatalf("%s: expected not found, got found=%v err=%v", txid, found, err)
+		}
+	}
+
+	n.warmup = true
+	if _, _, _, _, _, err := c.ConfirmationsFromTxHash(ctx, "mined"); !IsTransient(err) {
+		t.Fatalf("expected transient error while warming up, got %v", err)
+	}
+}
+
+func TestBitcoinClient_Headers(t *testing.T) {
+	n := newRegtestNode()
+	c := n.serve(t)
+	ctx := context.Background()
+
+	head, err := c.BlockNumber(ctx)
+	if err != nil || head != 110 {
+		t.Fatalf("BlockNumber: %d %v", head, err)
+	}
+	h, err := c.HeaderByNumber(ctx, 104)
+	if err != nil {
+		t.Fatalf("HeaderByNumber: %v", err)
+	}
+	if h.Hash != n.hashes[104] || h.ParentHash != n.hashes[103] {
+		t.Fatalf("unexpected header %+v", h)
+	}
+	if _, err := c.HeaderByNumber(ctx, 111); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound above the tip, got %v", err)
+	}
+
+	bad, _ := NewBitcoin(strings.Replace(c.url, "http://", "http://rpc:wrong@", 1))
+	var pe *PermanentError
+	if _, err := bad.BlockNumber(ctx); !errors.As(err, &pe) {
+		t.Fatalf("expected permanent error for bad credentials, got %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package chain
+
+import (
+	"context"
+	"math/big"
+)
+
+// Header is the subset of a block header the engine tracks.
+type Header struct {
+	Number     uint64
+	Hash       string
+	ParentHash string
+}
+
+// Tx is a top-level transaction as seen by the block scanner. To is empty for
+// contract creations.
+type Tx struct {
+	Hash  string
+	To    string
+	Value *big.Int
+}
+
+// Block is a header plus its top-level transactions.
+type Block struct {
+	Header
+	Txs []Tx
+}
+
+// HeaderSource is implemented by clients that can return canonical headers by number.
+type HeaderSource interface {
+	HeaderByNumber(ctx context.Context, number uint64) (*Header, error)
+}
+
+// BlockSource is implemented by clients that can return canonical headers and full blocks.
+// The engine's scanner needs it to discover deposits; clients without it only track known
+// tx hashes.
+type BlockSource interface {
+	HeaderSource
+	BlockByNumber(ctx context.Context, number uint64) (*Block, error)
+}
+
+// Block tags for TaggedHeadSource.
+const (
+	TagSafe      = "safe"
+	TagFinalized = "finalized"
+)
+
+// TaggedHeadSource is implemented by clients that can return the header behind a block
+// tag such as "safe" or "finalized" (post-merge Ethereum).
+type TaggedHeadSource interface {
+	TaggedHeader(ctx context.Context, tag string) (*Header, error)
+}
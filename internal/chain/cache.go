@@ -0,0 +1,187 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/lru"
+)
+
+// BlockInvalidator is implemented by clients that cache per-block data. The engine calls
+// InvalidateBlock for every block its header tracker reports orphaned.
+type BlockInvalidator interface {
+	InvalidateBlock(hash string)
+}
+
+// CachingClient decorates a ChainClient with LRU caches for data that cannot change while
+// its block stays canonical: receipts, keyed by tx hash, and a block's internal transfers,
+// keyed by block hash. Once a pending deposit's receipt is cached, each cycle costs one
+// BlockNumber call for all deposits instead of a receipt lookup per deposit.
+//
+// Cached entries are only dropped when InvalidateBlock reports their block orphaned (or
+// when evicted), so the client must be paired with a header tracker that sees every
+// reorg; callers that cannot rely on that (the engine's post-credit watch) check a
+// receipt's block against HeaderByNumber themselves. Lookups that find nothing are never
+// cached. Headers by number pass through: they
+// are how reorgs are detected in the first place.
+type CachingClient struct {
+	inner    ChainClient
+	receipts *lru.Cache[string, Receipt]
+	traces   *lru.Cache[string, []InternalTransfer]
+}
+
+// NewCaching wraps inner with caches of size entries each.
+func NewCaching(inner ChainClient, size int) *CachingClient {
+	return &CachingClient{
+		inner:    inner,
+		receipts: lru.NewCache[string, Receipt](size),
+		traces:   lru.NewCache[string, []InternalTransfer](size),
+	}
+}
+
+// InvalidateBlock drops everything cached from the block with hash.
+func (c *CachingClient) InvalidateBlock(hash string) {
+	c.traces.Remove(strings.ToLower(hash))
+	for _, tx := range c.receipts.Keys() {
+		if r, ok := c.receipts.Peek(tx); ok && strings.EqualFold(r.BlockHash, hash) {
+			c.receipts.Remove(tx)
+		}
+	}
+}
+
+func (c *CachingClient) BlockNumber(ctx context.Context) (uint64, error) {
+	return c.inner.BlockNumber(ctx)
+}
+
+func (c *CachingClient) Confirmations(ctx context.Context, txBlockNumber uint64) (uint64, error) {
+	return c.inner.Confirmations(ctx, txBlockNumber)
+}
+
+// ConfirmationsFromTxHash answers from a cached receipt plus the current head, or asks
+// the wrapped client and caches what it finds.
+func (c *CachingClient) ConfirmationsFromTxHash(ctx context.Context, txHash string) (uint64, uint64, string, bool, bool, error) {
+	if r, ok := c.receipts.Get(txHash); ok {
+		conf, err := c.inner.Confirmations(ctx, r.BlockNumber)
+		if err != nil {
+			return 0, 0, "", false, false, err
+		}
+		return r.BlockNumber, conf, r.BlockHash, true, r.Reverted, nil
+	}
+	txBlock, conf, blockHash, found, reverted, err := c.inner.ConfirmationsFromTxHash(ctx, txHash)
+	if err == nil && found && blockHash != "" {
+		c.receipts.Add(txHash, Receipt{TxHash: txHash, BlockNumber: txBlock, BlockHash: blockHash, Reverted: reverted})
+	}
+	return txBlock, conf, blockHash, found, reverted, err
+}
+
+// Receipts serves cached receipts and fetches only the rest, batched when the wrapped
+// client can batch and one by one otherwise.
+func (c *CachingClient) Receipts(ctx context.Context, txHashes []string) (map[string]*Receipt, error) {
+	res := make(map[string]*Receipt, len(txHashes))
+	var missing []string
+	for _, h := range txHashes {
+		if r, ok := c.receipts.Get(h); ok {
+			res[h] = &r
+			continue
+		}
+		missing = append(missing, h)
+	}
+	if len(missing) == 0 {
+		return res, nil
+	}
+
+	fetched, err := c.fetchReceipts(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	for h, r := range fetched {
+		res[h] = r
+		if r != nil && r.BlockHash != "" {
+			c.receipts.Add(h, *r)
+		}
+	}
+	return res, nil
+}
+
+func (c *CachingClient) fetchReceipts(ctx context.Context, txHashes []string) (map[string]*Receipt, error) {
+	if b, ok := c.inner.(ReceiptBatcher); ok {
+		res, err := b.Receipts(ctx, txHashes)
+		if !errors.Is(err, ErrUnsupported) {
+			return res, err
+		}
+	}
+	res := make(map[string]*Receipt, len(txHashes))
+	for _, h := range txHashes {
+		txBlock, _, blockHash, found, reverted, err := c.inner.ConfirmationsFromTxHash(ctx, h)
+		if errors.Is(err, ErrCircuitOpen) {
+			return nil, err
+		}
+		if err != nil {
+			continue // unknown this round
+		}
+		if !found {
+			res[h] = nil
+			continue
+		}
+		res[h] = &Receipt{TxHash: h, BlockNumber: txBlock, BlockHash: blockHash, Reverted: reverted}
+	}
+	return res, nil
+}
+
+// InternalTransfers caches a block's traces by its hash.
+func (c *CachingClient) InternalTransfers(ctx context.Context, number uint64, hash string) ([]InternalTransfer, error) {
+	key := strings.ToLower(hash)
+	if res, ok := c.traces.Get(key); ok {
+		return res, nil
+	}
+	src, ok := c.inner.(TraceSource)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	res, err := src.InternalTransfers(ctx, number, hash)
+	if err == nil {
+		c.traces.Add(key, res)
+	}
+	return res, err
+}
+
+func (c *CachingClient) HeaderByNumber(ctx context.Context, number uint64) (*Header, error) {
+	src, ok := c.inner.(HeaderSource)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return src.HeaderByNumber(ctx, number)
+}
+
+func (c *CachingClient) TaggedHeader(ctx context.Context, tag string) (*Header, error) {
+	src, ok := c.inner.(TaggedHeadSource)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return src.TaggedHeader(ctx, tag)
+}
+
+func (c *CachingClient) BlockByNumber(ctx context.Context, number uint64) (*Block, error) {
+	src, ok := c.inner.(BlockSource)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return src.BlockByNumber(ctx, number)
+}
+
+func (c *CachingClient) TransferLogs(ctx context.Context, from, to uint64, tokens []string) ([]TransferLog, error) {
+	src, ok := c.inner.(LogSource)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return src.TransferLogs(ctx, from, to, tokens)
+}
+
+func (c *CachingClient) SubscribeNewHeads(ctx context.Context, ch chan<- Header) (Subscription, error) {
+	src, ok := c.inner.(HeadSubscriber)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return src.SubscribeNewHeads(ctx, ch)
+}
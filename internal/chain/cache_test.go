@@ -0,0 +1,84 @@
+package chain
+
+import (
+	"context"
+	"math/big"
+	"testing"
+)
+
+// countingClient counts the calls that reach the node.
+type countingClient struct {
+	*Simulator
+	receipts, lookups int
+}
+
+func (c *countingClient) Receipts(ctx context.Context, txHashes []string) (map[string]*Receipt, error) {
+	c.receipts += len(txHashes)
+	return c.Simulator.Receipts(ctx, txHashes)
+}
+
+func (c *countingClient) ConfirmationsFromTxHash(ctx context.Context, txHash string) (uint64, uint64, string, bool, bool, error) {
+	c.lookups++
+	return c.Simulator.ConfirmationsFromTxHash(ctx, txHash)
+}
+
+func TestCachingClient_ServesReceiptsUntilBlockOrphaned(t *testing.T) {
+	sim := NewSimulator()
+	sim.Send(SimTx{Tx: Tx{Hash: "0xt1", To: "0xa1", Value: big.NewInt(1)}})
+	sim.Mine(1)
+	b1, _ := sim.HeaderByNumber(context.Background(), 1)
+	inner := &countingClient{Simulator: sim}
+	c := NewCaching(inner, 16)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		sim.Mine(1)
+		recs, err := c.Receipts(ctx, []string{"0xt1", "0xpending"})
+		if err != nil {
+			t.Fatalf("Receipts: %v", err)
+		}
+		if r := recs["0xt1"]; r == nil || r.BlockHash != b1.Hash {
+			t.Fatalf("unexpected receipt %+v", r)
+		}
+		if r, ok := recs["0xpending"]; !ok || r != nil {
+			t.Fatalf("expected a nil receipt for the unmined tx, got %+v", r)
+		}
+	}
+	// the mined receipt is fetched once; the missing one is asked for every time
+	if inner.receipts != 4 {
+		t.Fatalf("expected 4 receipt lookups, got %d", inner.receipts)
+	}
+	_, conf, _, found, _, _ := c.ConfirmationsFromTxHash(ctx, "0xt1")
+	if !found || conf != 4 || inner.lookups != 0 {
+		t.Fatalf("expected 4 confirmations from the cache, got %d (found %v, %d lookups)", conf, found, inner.lookups)
+	}
+
+	sim.Reorg(4)
+	sim.Drop("0xt1")
+	c.InvalidateBlock(b1.Hash)
+	if _, _, _, found, _, _ := c.ConfirmationsFromTxHash(ctx, "0xt1"); found || inner.lookups != 1 {
+		t.Fatalf("expected the orphaned receipt to be refetched and gone, found=%v lookups=%d", found, inner.lookups)
+	}
+}
+
+func TestCachingClient_FallsBackToSingleLookups(t *testing.T) {
+	sim := NewSimulator()
+	sim.Send(SimTx{Tx: Tx{Hash: "0xt1", To: "0xa1", Value: big.NewInt(1)}})
+	sim.Mine(2)
+	// hide the simulator's ReceiptBatcher
+	inner := &countingClient{Simulator: sim}
+	c := NewCaching(struct{ ChainClient }{inner}, 16)
+
+	for i := 0; i < 2; i++ {
+		recs, err := c.Receipts(context.Background(), []string{"0xt1"})
+		if err != nil {
+			t.Fatalf("Receipts: %v", err)
+		}
+		if r := recs["0xt1"]; r == nil || r.BlockNumber != 1 {
+			t.Fatalf("unexpected receipt %+v", r)
+		}
+	}
+	if inner.lookups != 1 {
+		t.Fatalf("expected one lookup, got %d", inner.lookups)
+	}
+}
@@ -2,19 +2,49 @@ package chain
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"math/big"
+	"strings"
+	"sync/atomic"
 
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/rpc"
 )
 
+// Backend is the node API a Client is built on. *ethclient.Client satisfies it, and so
+// can in-process backends such as go-ethereum's simulated backend (see package
+// chain/simulated).
+type Backend interface {
+	BlockNumber(ctx context.Context) (uint64, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+	FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error)
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+	SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error)
+}
+
+// rpcCaller issues raw JSON-RPC calls, for methods ethclient does not wrap.
+type rpcCaller interface {
+	CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error
+	BatchCallContext(ctx context.Context, b []rpc.BatchElem) error
+}
+
 type Client struct {
-	cli *ethclient.Client
+	cli Backend
+	// rpc is nil for backends without a JSON-RPC endpoint
+	rpc rpcCaller
+	// traceAPI is the tracing API the node was found to serve, see InternalTransfers
+	traceAPI atomic.Int32
 }
 
 // ChainClient defines the subset of chain behaviours we need. This allows tests to
-// inject a mock implementation.
+// inject a mock implementation. Errors are classified (see Classify): a missing receipt
+// is reported as found=false, never as an error, and any error means "unknown".
 type ChainClient interface {
 	BlockNumber(ctx context.Context) (uint64, error)
 	Confirmations(ctx context.Context, txBlockNumber uint64) (uint64, error)
@@ -24,16 +54,22 @@ type ChainClient interface {
 func New(url string) (*Client, error) {
 	c, err := ethclient.Dial(url)
 	if err != nil {
-		return nil, err
+		return nil, Classify(err)
 	}
-	return &Client{cli: c}, nil
+	return &Client{cli: c, rpc: c.Client()}, nil
+}
+
+// NewFromBackend builds a Client on b. Without a JSON-RPC endpoint behind it, receipt
+// batching and tracing report ErrUnsupported and callers fall back to single lookups.
+func NewFromBackend(b Backend) *Client {
+	return &Client{cli: b}
 }
 
 // BlockNumber returns the latest block number.
 func (c *Client) BlockNumber(ctx context.Context) (uint64, error) {
 	h, err := c.cli.BlockNumber(ctx)
 	if err != nil {
-		return 0, err
+		return 0, Classify(err)
 	}
 	return h, nil
 }
@@ -59,6 +95,155 @@ func bigToU64(b *big.Int) uint64 {
 	return b.Uint64()
 }
 
+// HeaderByNumber fetches the canonical header at number.
+func (c *Client) HeaderByNumber(ctx context.Context, number uint64) (*Header, error) {
+	h, err := c.cli.HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+	if err != nil {
+		return nil, Classify(err)
+	}
+	return &Header{Number: h.Number.Uint64(), Hash: h.Hash().Hex(), ParentHash: h.ParentHash.Hex()}, nil
+}
+
+// TaggedHeader fetches the header behind the "safe" or "finalized" block tag.
+func (c *Client) TaggedHeader(ctx context.Context, tag string) (*Header, error) {
+	var n rpc.BlockNumber
+	switch tag {
+	case TagSafe:
+		n = rpc.SafeBlockNumber
+	case TagFinalized:
+		n = rpc.FinalizedBlockNumber
+	default:
+		return nil, &PermanentError{Err: fmt.Errorf("unknown block tag %q", tag)}
+	}
+	h, err := c.cli.HeaderByNumber(ctx, big.NewInt(n.Int64()))
+	if err != nil {
+		return nil, Classify(err)
+	}
+	return &Header{Number: h.Number.Uint64(), Hash: h.Hash().Hex(), ParentHash: h.ParentHash.Hex()}, nil
+}
+
+// SubscribeNewHeads forwards new heads from an eth_subscribe("newHeads") feed to ch. The
+// client must have been dialed over ws:// (or IPC); over HTTP this returns an error.
+func (c *Client) SubscribeNewHeads(ctx context.Context, ch chan<- Header) (Subscription, error) {
+	raw := make(chan *types.Header)
+	sub, err := c.cli.SubscribeNewHead(ctx, raw)
+	if err != nil {
+		return nil, Classify(err)
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case h := <-raw:
+				select {
+				case ch <- Header{Number: h.Number.Uint64(), Hash: h.Hash().Hex(), ParentHash: h.ParentHash.Hex()}:
+				case <-quit:
+					return nil
+				}
+			case err := <-sub.Err():
+				return Classify(err)
+			case <-quit:
+				return nil
+			}
+		}
+	}), nil
+}
+
+// BlockByNumber fetches a block with its transactions. Addresses are lower-cased so
+// callers can match them against stored account addresses directly.
+func (c *Client) BlockByNumber(ctx context.Context, number uint64) (*Block, error) {
+	b, err := c.cli.BlockByNumber(ctx, new(big.Int).SetUint64(number))
+	if err != nil {
+		return nil, Classify(err)
+	}
+	res := &Block{
+		Header: Header{Number: b.NumberU64(), Hash: b.Hash().Hex(), ParentHash: b.ParentHash().Hex()},
+		Txs:    make([]Tx, 0, len(b.Transactions())),
+	}
+	for _, tx := range b.Transactions() {
+		var to string
+		if tx.To() != nil {
+			to = strings.ToLower(tx.To().Hex())
+		}
+		res.Txs = append(res.Txs, Tx{Hash: tx.Hash().Hex(), To: to, Value: tx.Value()})
+	}
+	return res, nil
+}
+
+// TransferLogs returns the ERC-20 Transfer events emitted by tokens in [from, to].
+// Logs that do not decode as a standard Transfer (e.g. ERC-721) are skipped.
+func (c *Client) TransferLogs(ctx context.Context, from, to uint64, tokens []string) ([]TransferLog, error) {
+	q := ethereum.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(from),
+		ToBlock:   new(big.Int).SetUint64(to),
+		Topics:    [][]common.Hash{{TransferTopic}},
+	}
+	for _, t := range tokens {
+		q.Addresses = append(q.Addresses, common.HexToAddress(t))
+	}
+	logs, err := c.cli.FilterLogs(ctx, q)
+	if err != nil {
+		return nil, Classify(err)
+	}
+	res := make([]TransferLog, 0, len(logs))
+	for _, l := range logs {
+		if l.Removed || len(l.Topics) != 3 || len(l.Data) != 32 {
+			continue
+		}
+		res = append(res, TransferLog{
+			TxHash:      l.TxHash.Hex(),
+			LogIndex:    l.Index,
+			BlockNumber: l.BlockNumber,
+			BlockHash:   l.BlockHash.Hex(),
+			Token:       strings.ToLower(l.Address.Hex()),
+			From:        strings.ToLower(common.BytesToAddress(l.Topics[1].Bytes()).Hex()),
+			To:          strings.ToLower(common.BytesToAddress(l.Topics[2].Bytes()).Hex()),
+			Value:       new(big.Int).SetBytes(l.Data),
+		})
+	}
+	return res, nil
+}
+
+// Receipts fetches receipts with batched eth_getTransactionReceipt calls, receiptBatchSize
+// per request.
+func (c *Client) Receipts(ctx context.Context, txHashes []string) (map[string]*Receipt, error) {
+	if c.rpc == nil {
+		return nil, ErrUnsupported
+	}
+	res := make(map[string]*Receipt, len(txHashes))
+	for start := 0; start < len(txHashes); start += receiptBatchSize {
+		end := start + receiptBatchSize
+		if end > len(txHashes) {
+			end = len(txHashes)
+		}
+		chunk := txHashes[start:end]
+		raws := make([]*types.Receipt, len(chunk))
+		elems := make([]rpc.BatchElem, len(chunk))
+		for i, h := range chunk {
+			elems[i] = rpc.BatchElem{Method: "eth_getTransactionReceipt", Args: []interface{}{common.HexToHash(h)}, Result: &raws[i]}
+		}
+		if err := c.rpc.BatchCallContext(ctx, elems); err != nil {
+			return nil, Classify(err)
+		}
+		for i, e := range elems {
+			if e.Error != nil {
+				continue
+			}
+			if raws[i] == nil {
+				res[chunk[i]] = nil
+				continue
+			}
+			res[chunk[i]] = &Receipt{
+				TxHash:      chunk[i],
+				BlockNumber: bigToU64(raws[i].BlockNumber),
+				BlockHash:   raws[i].BlockHash.Hex(),
+				Reverted:    raws[i].Status == types.ReceiptStatusFailed,
+			}
+		}
+	}
+	return res, nil
+}
+
 // ConfirmationsFromTxHash fetches the tx receipt and returns the block number and confirmations.
 func (c *Client) ConfirmationsFromTxHash(ctx context.Context, txHash string) (txBlock uint64, confirmations uint64, blockHash string, found bool, reverted bool, err error) {
 	// use underlying rpc client to get receipt
@@ -66,9 +251,12 @@ func (c *Client) ConfirmationsFromTxHash(ctx context.Context, txHash string) (tx
 	h := common.HexToHash(txHash)
 	rec, err := c.cli.TransactionReceipt(ctx, h)
 	if err != nil {
-		// if receipt not found, return found=false
-		// note: client.TransactionReceipt returns an error when not found
-		return 0, 0, "", false, false, nil
+		// only a definite "not found" means the receipt is gone; timeouts, rate limits and
+		// other failures must not be mistaken for a reorg
+		if err = Classify(err); errors.Is(err, ErrNotFound) {
+			return 0, 0, "", false, false, nil
+		}
+		return 0, 0, "", false, false, err
 	}
 	if rec == nil {
 		return 0, 0, "", false, false, nil
@@ -0,0 +1,114 @@
+package chain
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type rpcRequest struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcErrorObject `json:"error,omitempty"`
+}
+
+// rpcErrorObject, returned from a handler, is sent as the JSON-RPC error.
+type rpcErrorObject struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func newRPCResponse(id json.RawMessage, result interface{}) rpcResponse {
+	if e, ok := result.(*rpcErrorObject); ok {
+		return rpcResponse{JSONRPC: "2.0", ID: id, Error: e}
+	}
+	if result == nil {
+		// a JSON null result must still be sent
+		result = json.RawMessage("null")
+	}
+	return rpcResponse{JSONRPC: "2.0", ID: id, Result: result}
+}
+
+// newRPCServer serves JSON-RPC requests (single or batched) from handle and returns a
+// Client dialed to it. A *rpcErrorObject result is sent as an error. batches counts the batched HTTP requests received.
+func newRPCServer(t *testing.T, handle func(method string, params json.RawMessage) interface{}) (c *Client, batches *int) {
+	t.Helper()
+	batches = new(int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var raw json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if len(raw) > 0 && raw[0] == '[' {
+			*batches++
+			var reqs []rpcRequest
+			_ = json.Unmarshal(raw, &reqs)
+			resps := make([]rpcResponse, len(reqs))
+			for i, req := range reqs {
+				resps[i] = newRPCResponse(req.ID, handle(req.Method, req.Params))
+			}
+			_ = json.NewEncoder(w).Encode(resps)
+			return
+		}
+		var req rpcRequest
+		_ = json.Unmarshal(raw, &req)
+		_ = json.NewEncoder(w).Encode(newRPCResponse(req.ID, handle(req.Method, req.Params)))
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(srv.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	return c, batches
+}
+
+func TestClient_ReceiptsUsesOneBatch(t *testing.T) {
+	const mined = "0x00000000000000000000000000000000000000000000000000000000000000aa"
+	c, batches := newRPCServer(t, func(method string, params json.RawMessage) interface{} {
+		var args []string
+		_ = json.Unmarshal(params, &args)
+		if method != "eth_getTransactionReceipt" || args[0] != mined {
+			return nil
+		}
+		return map[string]interface{}{
+			"transactionHash":   mined,
+			"blockHash":         "0x00000000000000000000000000000000000000000000000000000000000000bb",
+			"blockNumber":       "0x5a",
+			"status":            "0x0",
+			"cumulativeGasUsed": "0x5208",
+			"gasUsed":           "0x5208",
+			"logs":              []interface{}{},
+			"logsBloom":         "0x" + strings.Repeat("0", 512),
+			"transactionIndex":  "0x0",
+		}
+	})
+
+	missing := "0x00000000000000000000000000000000000000000000000000000000000000cc"
+	recs, err := c.Receipts(context.Background(), []string{mined, missing})
+	if err != nil {
+		t.Fatalf("Receipts: %v", err)
+	}
+	if *batches != 1 {
+		t.Fatalf("expected 1 batch request, got %d", *batches)
+	}
+	r, ok := recs[mined]
+	if !ok || r == nil || r.BlockNumber != 90 || !r.Reverted {
+		t.Fatalf("unexpected receipt %+v", r)
+	}
+	if r, ok := recs[missing]; !ok || r != nil {
+		t.Fatalf("expected explicit not-found for %s, got %+v (present=%v)", missing, r, ok)
+	}
+}
@@ -0,0 +1,52 @@
+package chain
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Create2Address computes the address a CREATE2 deployment from factory will have:
+// keccak256(0xff ++ factory ++ salt ++ initCodeHash)[12:]. The contract need not exist
+// yet, so funds can be sent to it before it is deployed. The result is lower-cased.
+func Create2Address(factory string, salt [32]byte, initCodeHash [32]byte) (string, error) {
+	if !common.IsHexAddress(factory) {
+		return "", fmt.Errorf("create2: invalid factory address %q", factory)
+	}
+	addr := crypto.CreateAddress2(common.HexToAddress(factory), salt, initCodeHash[:])
+	return strings.ToLower(addr.Hex()), nil
+}
+
+// ForwarderSalt is the CREATE2 salt of the forwarder owned by address: the address
+// left-padded to 32 bytes, as forwarder factories commonly take bytes32(uint160(owner)).
+func ForwarderSalt(owner string) ([32]byte, error) {
+	if !common.IsHexAddress(owner) {
+		return [32]byte{}, fmt.Errorf("create2: invalid owner address %q", owner)
+	}
+	return common.BytesToHash(common.HexToAddress(owner).Bytes()), nil
+}
+
+// ForwarderAddress computes the counterfactual address of owner's forwarder, deployed by
+// factory from init code hashing to initCodeHash (hex, 32 bytes).
+func ForwarderAddress(factory, initCodeHash, owner string) (string, error) {
+	h, err := parseHash(initCodeHash)
+	if err != nil {
+		return "", err
+	}
+	salt, err := ForwarderSalt(owner)
+	if err != nil {
+		return "", err
+	}
+	return Create2Address(factory, salt, h)
+}
+
+func parseHash(s string) ([32]byte, error) {
+	b, err := hexutil.Decode(s)
+	if err != nil || len(b) != 32 {
+		return [32]byte{}, fmt.Errorf("create2: init code hash %q is not 32 hex bytes", s)
+	}
+	return common.BytesToHash(b), nil
+}
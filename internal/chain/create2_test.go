@@ -0,0 +1,58 @@
+package chain
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// EIP-1014 examples
+func TestCreate2Address(t *testing.T) {
+	cases := []struct {
+		factory, salt, initCode, want string
+	}{
+		{"0x0000000000000000000000000000000000000000", "0x00", "0x00", "0x4D1A2e2bB4F88F0250f26Ffff098B0b30B26BF38"},
+		{"0xdeadbeef00000000000000000000000000000000", "0x00", "0x00", "0xB928f69Bb1D91Cd65274e3c79d8986362984fDA3"},
+		{"0xdeadbeef00000000000000000000000000000000", "0xfeed000000000000000000000000000000000000", "0x00", "0xD04116cDd17beBE565EB2422F2497E06cC1C9833"},
+		{"0x00000000000000000000000000000000deadbeef", "0xcafebabe", "0xdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef", "0x1d8bfDC5D46DC4f61D6b6115972536eBE6A8854C"},
+	}
+	for _, c := range cases {
+		var codeHash [32]byte
+		copy(codeHash[:], crypto.Keccak256(hexutil.MustDecode(c.initCode)))
+		got, err := Create2Address(c.factory, common.HexToHash(c.salt), codeHash)
+		if err != nil {
+			t.Fatalf("Create2Address: %v", err)
+		}
+		if got != strings.ToLower(c.want) {
+			t.Fatalf("factory %s salt %s: got %s, want %s", c.factory, c.salt, got, c.want)
+		}
+	}
+}
+
+func TestForwarderAddress(t *testing.T) {
+	factory := "0xdeadbeef00000000000000000000000000000000"
+	codeHash := hexutil.Encode(crypto.Keccak256([]byte{0}))
+	owner := "0x000000000000000000000000000000000000FEED"
+
+	got, err := ForwarderAddress(factory, codeHash, owner)
+	if err != nil {
+		t.Fatalf("ForwarderAddress: %v", err)
+	}
+	// bytes32(uint160(0xfeed)) as the salt
+	var h [32]byte
+	copy(h[:], crypto.Keccak256([]byte{0}))
+	want, _ := Create2Address(factory, common.HexToHash("0xfeed"), h)
+	if got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	if _, err := ForwarderAddress(factory, "0x1234", owner); err == nil {
+		t.Fatalf("expected error for a short init code hash")
+	}
+	if _, err := ForwarderAddress("factory", codeHash, owner); err == nil {
+		t.Fatalf("expected error for an invalid factory")
+	}
+}
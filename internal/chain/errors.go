@@ -0,0 +1,77 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var (
+	// ErrNotFound means the node answered and the object (receipt, block, ...) does not exist.
+	ErrNotFound = errors.New("chain: not found")
+	// ErrUnsupported means the client, or the node behind it, lacks an optional capability.
+	ErrUnsupported = errors.New("chain: unsupported")
+	// ErrCircuitOpen is returned without calling the node while the circuit breaker is open.
+	ErrCircuitOpen = errors.New("chain: circuit open")
+)
+
+// TransientError wraps failures that may succeed on retry: timeouts, dropped connections,
+// rate limits and 5xx responses.
+type TransientError struct{ Err error }
+
+func (e *TransientError) Error() string { return fmt.Sprintf("chain: transient: %v", e.Err) }
+func (e *TransientError) Unwrap() error { return e.Err }
+
+// PermanentError wraps failures that will not go away on retry, such as invalid params or
+// a method the node does not serve.
+type PermanentError struct{ Err error }
+
+func (e *PermanentError) Error() string { return fmt.Sprintf("chain: permanent: %v", e.Err) }
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// IsTransient reports whether err is worth retrying.
+func IsTransient(err error) bool {
+	var te *TransientError
+	return errors.As(err, &te)
+}
+
+// Classify maps an error from the RPC layer onto ErrNotFound, *TransientError or
+// *PermanentError. Context cancellation and already-classified errors pass through.
+// Unrecognised errors are treated as transient: retrying is cheap, acting on a
+// misread answer is not.
+func Classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	var te *TransientError
+	var pe *PermanentError
+	switch {
+	case errors.As(err, &te), errors.As(err, &pe), errors.Is(err, ErrNotFound),
+		errors.Is(err, ErrUnsupported), errors.Is(err, ErrCircuitOpen), errors.Is(err, context.Canceled):
+		return err
+	case errors.Is(err, ethereum.NotFound):
+		return ErrNotFound
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return &TransientError{Err: err}
+	}
+
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		if httpErr.StatusCode == 429 || httpErr.StatusCode >= 500 {
+			return &TransientError{Err: err}
+		}
+		return &PermanentError{Err: err}
+	}
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		switch rpcErr.ErrorCode() {
+		case -32600, -32601, -32602: // invalid request, method not found, invalid params
+			return &PermanentError{Err: err}
+		}
+	}
+	return &TransientError{Err: err}
+}
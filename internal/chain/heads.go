@@ -0,0 +1,16 @@
+package chain
+
+import "context"
+
+// Subscription is a live feed that can be cancelled. Err delivers the reason the feed
+// ended and is closed when it does; go-ethereum's event.Subscription satisfies it.
+type Subscription interface {
+	Err() <-chan error
+	Unsubscribe()
+}
+
+// HeadSubscriber is implemented by clients that can push new canonical heads, which
+// requires a websocket (or IPC) endpoint.
+type HeadSubscriber interface {
+	SubscribeNewHeads(ctx context.Context, ch chan<- Header) (Subscription, error)
+}
@@ -0,0 +1,177 @@
+package chain
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// BatchOracle reports how far an L2 chain is backed by L1: the highest L2 block whose
+// batch is included in the L1 chain at the given tag. TagSafe means the batch is posted
+// to L1 but that L1 block may still reorg; TagFinalized means the L1 block is finalized.
+type BatchOracle interface {
+	L2Head(ctx context.Context, tag string) (*Header, error)
+}
+
+// L2Client decorates the ChainClient of an L2 node (OP-stack, Arbitrum) so that its safe
+// and finalized heads come from a BatchOracle. With FinalityMode "finalized" the engine
+// then credits an L2 deposit only once its block is covered by a finalized L1 batch. All
+// other calls go to the L2 node.
+type L2Client struct {
+	inner  ChainClient
+	oracle BatchOracle
+	// verify checks the oracle's heads against the node; pointless when they are the node's own
+	verify bool
+}
+
+// NewL2 wraps an L2 node's client. A nil oracle uses the node's own safe and finalized
+// tags, which OP-stack and Arbitrum Nitro nodes already derive from L1.
+func NewL2(inner ChainClient, oracle BatchOracle) *L2Client {
+	if oracle == nil {
+		return &L2Client{inner: inner, oracle: NodeTagOracle{Node: inner}}
+	}
+	return &L2Client{inner: inner, oracle: oracle, verify: true}
+}
+
+func (l *L2Client) BlockNumber(ctx context.Context) (uint64, error) {
+	return l.inner.BlockNumber(ctx)
+}
+
+func (l *L2Client) Confirmations(ctx context.Context, txBlockNumber uint64) (uint64, error) {
+	return l.inner.Confirmations(ctx, txBlockNumber)
+}
+
+func (l *L2Client) ConfirmationsFromTxHash(ctx context.Context, txHash string) (uint64, uint64, string, bool, bool, error) {
+	return l.inner.ConfirmationsFromTxHash(ctx, txHash)
+}
+
+// TaggedHeader returns the L2 head the oracle reports as covered by L1 at tag. With a
+// separate oracle, its block must be canonical on the L2 node too; a mismatch means
+// the two disagree for now (one is lagging or mid-reorg) and is reported as transient.
+func (l *L2Client) TaggedHeader(ctx context.Context, tag string) (*Header, error) {
+	h, err := l.oracle.L2Head(ctx, tag)
+	if err != nil {
+		return nil, Classify(err)
+	}
+	src, ok := l.inner.(HeaderSource)
+	if !l.verify || !ok {
+		return h, nil
+	}
+	canon, err := src.HeaderByNumber(ctx, h.Number)
+	if err != nil {
+		return nil, &TransientError{Err: fmt.Errorf("%s L2 block %d not on the L2 node: %w", tag, h.Number, err)}
+	}
+	if !strings.EqualFold(canon.Hash, h.Hash) {
+		return nil, &TransientError{Err: fmt.Errorf("%s L2 block %d: oracle hash %s, node hash %s", tag, h.Number, h.Hash, canon.Hash)}
+	}
+	return h, nil
+}
+
+func (l *L2Client) HeaderByNumber(ctx context.Context, number uint64) (*Header, error) {
+	src, ok := l.inner.(HeaderSource)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return src.HeaderByNumber(ctx, number)
+}
+
+func (l *L2Client) BlockByNumber(ctx context.Context, number uint64) (*Block, error) {
+	src, ok := l.inner.(BlockSource)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return src.BlockByNumber(ctx, number)
+}
+
+func (l *L2Client) TransferLogs(ctx context.Context, from, to uint64, tokens []string) ([]TransferLog, error) {
+	src, ok := l.inner.(LogSource)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return src.TransferLogs(ctx, from, to, tokens)
+}
+
+func (l *L2Client) Receipts(ctx context.Context, txHashes []string) (map[string]*Receipt, error) {
+	src, ok := l.inner.(ReceiptBatcher)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return src.Receipts(ctx, txHashes)
+}
+
+func (l *L2Client) InternalTransfers(ctx context.Context, number uint64, hash string) ([]InternalTransfer, error) {
+	src, ok := l.inner.(TraceSource)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return src.InternalTransfers(ctx, number, hash)
+}
+
+func (l *L2Client) SubscribeNewHeads(ctx context.Context, ch chan<- Header) (Subscription, error) {
+	src, ok := l.inner.(HeadSubscriber)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return src.SubscribeNewHeads(ctx, ch)
+}
+
+// NodeTagOracle reads the L1-backed heads from the L2 node's own block tags.
+type NodeTagOracle struct {
+	Node ChainClient
+}
+
+func (o NodeTagOracle) L2Head(ctx context.Context, tag string) (*Header, error) {
+	src, ok := o.Node.(TaggedHeadSource)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return src.TaggedHeader(ctx, tag)
+}
+
+// OPNodeOracle reads the L1-backed heads from an OP-stack rollup node (op-node) via
+// optimism_syncStatus, independently of the L2 execution client.
+type OPNodeOracle struct {
+	rpc rpcCaller
+}
+
+// NewOPNodeOracle dials the op-node rollup RPC at url.
+func NewOPNodeOracle(url string) (*OPNodeOracle, error) {
+	c, err := rpc.Dial(url)
+	if err != nil {
+		return nil, Classify(err)
+	}
+	return &OPNodeOracle{rpc: c}, nil
+}
+
+type opBlockRef struct {
+	Hash       string `json:"hash"`
+	Number     uint64 `json:"number"`
+	ParentHash string `json:"parentHash"`
+}
+
+type opSyncStatus struct {
+	SafeL2      opBlockRef `json:"safe_l2"`
+	FinalizedL2 opBlockRef `json:"finalized_l2"`
+}
+
+func (o *OPNodeOracle) L2Head(ctx context.Context, tag string) (*Header, error) {
+	var st opSyncStatus
+	if err := o.rpc.CallContext(ctx, &st, "optimism_syncStatus"); err != nil {
+		return nil, Classify(err)
+	}
+	var ref opBlockRef
+	switch tag {
+	case TagSafe:
+		ref = st.SafeL2
+	case TagFinalized:
+		ref = st.FinalizedL2
+	default:
+		return nil, &PermanentError{Err: fmt.Errorf("unknown block tag %q", tag)}
+	}
+	if ref.Hash == "" {
+		return nil, ErrNotFound
+	}
+	return &Header{Number: ref.Number, Hash: ref.Hash, ParentHash: ref.ParentHash}, nil
+}
@@ -0,0 +1,75 @@
+package chain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// stubOracle reports fixed L2 heads per tag.
+type stubOracle map[string]*Header
+
+func (o stubOracle) L2Head(ctx context.Context, tag string) (*Header, error) {
+	h, ok := o[tag]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return h, nil
+}
+
+func TestL2Client_FinalizedHeadComesFromOracle(t *testing.T) {
+	ctx := context.Background()
+	m := NewMock()
+	m.Block = 120
+	m.Tags[TagFinalized] = 119 // the L2 node's own tag is ignored with an oracle
+	m.Blocks[100] = &Block{Header: Header{Number: 100, Hash: "0xaa"}}
+
+	l2 := NewL2(m, stubOracle{TagFinalized: {Number: 100, Hash: "0xAA"}})
+	h, err := l2.TaggedHeader(ctx, TagFinalized)
+	if err != nil {
+		t.Fatalf("TaggedHeader: %v", err)
+	}
+	if h.Number != 100 {
+		t.Fatalf("expected finalized L2 head 100, got %d", h.Number)
+	}
+
+	// the oracle and the L2 node disagree on block 100: no answer rather than a wrong one
+	l2 = NewL2(m, stubOracle{TagFinalized: {Number: 100, Hash: "0xbb"}})
+	if _, err := l2.TaggedHeader(ctx, TagFinalized); !IsTransient(err) {
+		t.Fatalf("expected transient error on hash mismatch, got %v", err)
+	}
+
+	// without an oracle the node's own tag is used
+	if h, err := NewL2(m, nil).TaggedHeader(ctx, TagFinalized); err != nil || h.Number != 119 {
+		t.Fatalf("expected node tag 119, got %+v (%v)", h, err)
+	}
+}
+
+func TestOPNodeOracle_ReadsSyncStatus(t *testing.T) {
+	c, _ := newRPCServer(t, func(method string, params json.RawMessage) interface{} {
+		if method != "optimism_syncStatus" {
+			return nil
+		}
+		return map[string]interface{}{
+			"unsafe_l2":    map[string]interface{}{"hash": "0x03", "number": 130, "parentHash": "0x02"},
+			"safe_l2":      map[string]interface{}{"hash": "0x02", "number": 120, "parentHash": "0x01"},
+			"finalized_l2": map[string]interface{}{"hash": "0x01", "number": 100, "parentHash": "0x00"},
+		}
+	})
+	o := &OPNodeOracle{rpc: c.rpc}
+
+	ctx := context.Background()
+	fin, err := o.L2Head(ctx, TagFinalized)
+	if err != nil || fin.Number != 100 || fin.Hash != "0x01" {
+		t.Fatalf("finalized: got %+v (%v)", fin, err)
+	}
+	safe, err := o.L2Head(ctx, TagSafe)
+	if err != nil || safe.Number != 120 {
+		t.Fatalf("safe: got %+v (%v)", safe, err)
+	}
+	var pe *PermanentError
+	if _, err := o.L2Head(ctx, "latest"); !errors.As(err, &pe) {
+		t.Fatalf("expected permanent error for unknown tag, got %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package chain
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// TransferTopic is the topic of the ERC-20 Transfer(address,address,uint256) event.
+var TransferTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+// TransferLog is a decoded ERC-20 Transfer event. Addresses are lower-cased.
+type TransferLog struct {
+	TxHash      string
+	LogIndex    uint
+	BlockNumber uint64
+	BlockHash   string
+	Token       string
+	From        string
+	To          string
+	Value       *big.Int
+}
+
+// LogSource is implemented by clients that can query ERC-20 Transfer events for a set of
+// token contracts over an inclusive block range.
+type LogSource interface {
+	TransferLogs(ctx context.Context, from, to uint64, tokens []string) ([]TransferLog, error)
+}
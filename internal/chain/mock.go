@@ -2,6 +2,10 @@ package chain
 
 import (
 	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/event"
 )
 
 // MockClient is a simple test double for ChainClient.
@@ -12,6 +16,16 @@ type MockClient struct {
 		Hash     string
 		Reverted bool
 	}
+	Blocks map[uint64]*Block
+	Logs   []TransferLog
+	// Traces holds the internal transfers of each block number.
+	Traces map[uint64][]InternalTransfer
+	// Tags maps a block tag ("safe", "finalized") to its block number.
+	Tags map[string]uint64
+	// Heads feeds the current head subscription; sending on Drops ends it with that error.
+	Heads        chan Header
+	Drops        chan error
+	SubscribeErr error
 }
 
 func NewMock() *MockClient {
@@ -19,7 +33,7 @@ func NewMock() *MockClient {
 		Block    uint64
 		Hash     string
 		Reverted bool
-	})}
+	}), Blocks: make(map[uint64]*Block), Traces: make(map[uint64][]InternalTransfer), Tags: make(map[string]uint64), Heads: make(chan Header), Drops: make(chan error)}
 }
 
 func (m *MockClient) BlockNumber(ctx context.Context) (uint64, error) { return m.Block, nil }
@@ -39,3 +53,85 @@ func (m *MockClient) ConfirmationsFromTxHash(ctx context.Context, txHash string)
 	conf, _ := m.Confirmations(ctx, info.Block)
 	return info.Block, conf, info.Hash, true, info.Reverted, nil
 }
+
+func (m *MockClient) HeaderByNumber(ctx context.Context, number uint64) (*Header, error) {
+	b, err := m.BlockByNumber(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	h := b.Header
+	return &h, nil
+}
+
+func (m *MockClient) BlockByNumber(ctx context.Context, number uint64) (*Block, error) {
+	b, ok := m.Blocks[number]
+	if !ok {
+		return nil, fmt.Errorf("block %d: %w", number, ErrNotFound)
+	}
+	return b, nil
+}
+
+func (m *MockClient) TransferLogs(ctx context.Context, from, to uint64, tokens []string) ([]TransferLog, error) {
+	allowed := make(map[string]bool, len(tokens))
+	for _, t := range tokens {
+		allowed[strings.ToLower(t)] = true
+	}
+	var res []TransferLog
+	for _, l := range m.Logs {
+		if l.BlockNumber >= from && l.BlockNumber <= to && allowed[l.Token] {
+			res = append(res, l)
+		}
+	}
+	return res, nil
+}
+
+func (m *MockClient) SubscribeNewHeads(ctx context.Context, ch chan<- Header) (Subscription, error) {
+	if m.SubscribeErr != nil {
+		return nil, m.SubscribeErr
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		for {
+			select {
+			case h := <-m.Heads:
+				select {
+				case ch <- h:
+				case <-quit:
+					return nil
+				}
+			case err := <-m.Drops:
+				return err
+			case <-quit:
+				return nil
+			}
+		}
+	}), nil
+}
+
+func (m *MockClient) Receipts(ctx context.Context, txHashes []string) (map[string]*Receipt, error) {
+	res := make(map[string]*Receipt, len(txHashes))
+	for _, h := range txHashes {
+		info, ok := m.TxInfo[h]
+		if !ok {
+			res[h] = nil
+			continue
+		}
+		res[h] = &Receipt{TxHash: h, BlockNumber: info.Block, BlockHash: info.Hash, Reverted: info.Reverted}
+	}
+	return res, nil
+}
+
+func (m *MockClient) TaggedHeader(ctx context.Context, tag string) (*Header, error) {
+	n, ok := m.Tags[tag]
+	if !ok {
+		return nil, fmt.Errorf("tag %s: %w", tag, ErrNotFound)
+	}
+	if b, ok := m.Blocks[n]; ok {
+		h := b.Header
+		return &h, nil
+	}
+	return &Header{Number: n}, nil
+}
+
+func (m *MockClient) InternalTransfers(ctx context.Context, number uint64, hash string) ([]InternalTransfer, error) {
+	return m.Traces[number], nil
+}
@@ -0,0 +1,399 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrNoQuorum means the providers did not agree on a receipt often enough to trust it.
+var ErrNoQuorum = errors.New("chain: providers disagree, no quorum")
+
+// Provider is one named RPC endpoint for a MultiClient.
+type Provider struct {
+	Name   string
+	Client ChainClient
+}
+
+// ProviderStatus is the last observed health of a provider.
+type ProviderStatus struct {
+	Name    string
+	Head    uint64
+	Healthy bool
+	Lagging bool
+	LastErr error
+}
+
+// MultiClient spreads calls over several providers. BlockNumber probes every provider and
+// records its head height; other calls go to the first healthy provider that is at most
+// maxLag blocks behind the best head, failing over to the next one on error. With a quorum
+// above 1, a receipt only counts once that many providers report the same block hash.
+type MultiClient struct {
+	providers []Provider
+	maxLag    uint64
+	quorum    int
+
+	mu     sync.Mutex
+	status []ProviderStatus
+}
+
+// NewMulti creates a MultiClient over providers, tried in the given order. A quorum above 1
+// must be a majority of the providers, so that two different answers can never both reach it.
+func NewMulti(providers []Provider, maxLag uint64, quorum int) (*MultiClient, error) {
+	if quorum > 1 && (quorum <= len(providers)/2 || quorum > len(providers)) {
+		return nil, fmt.Errorf("chain: quorum %d is not a majority of %d providers", quorum, len(providers))
+	}
+	status := make([]ProviderStatus, len(providers))
+	for i, p := range providers {
+		status[i] = ProviderStatus{Name: p.Name, Healthy: true}
+	}
+	return &MultiClient{providers: providers, maxLag: maxLag, quorum: quorum, status: status}, nil
+}
+
+// Health returns a snapshot of every provider's status.
+func (m *MultiClient) Health() []ProviderStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]ProviderStatus(nil), m.status...)
+}
+
+// BlockNumber queries all providers concurrently, refreshes their health and returns the
+// highest head reported by a healthy provider.
+func (m *MultiClient) BlockNumber(ctx context.Context) (uint64, error) {
+	heads := make([]uint64, len(m.providers))
+	errs := make([]error, len(m.providers))
+	var wg sync.WaitGroup
+	for i, p := range m.providers {
+		wg.Add(1)
+		go func(i int, c ChainClient) {
+			defer wg.Done()
+			heads[i], errs[i] = c.BlockNumber(ctx)
+		}(i, p.Client)
+	}
+	wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var best uint64
+	var ok bool
+	for i := range m.providers {
+		m.status[i].Healthy = errs[i] == nil
+		m.status[i].LastErr = errs[i]
+		if errs[i] == nil {
+			ok = true
+			m.status[i].Head = heads[i]
+			if heads[i] > best {
+				best = heads[i]
+			}
+		}
+	}
+	for i := range m.status {
+		m.status[i].Lagging = m.maxLag > 0 && m.status[i].Healthy && best-m.status[i].Head > m.maxLag
+	}
+	if !ok {
+		return 0, Classify(errors.Join(errs...))
+	}
+	return best, nil
+}
+
+// candidates returns the providers to try, healthy and up to date ones first. Unhealthy
+// providers are kept at the end so a call can still succeed when every probe failed.
+func (m *MultiClient) candidates() []int {
+	good, rest := m.partition()
+	return append(good, rest...)
+}
+
+// partition splits the providers into healthy, up to date ones and the rest.
+func (m *MultiClient) partition() (good, rest []int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, s := range m.status {
+		if s.Healthy && !s.Lagging {
+			good = append(good, i)
+		} else {
+			rest = append(rest, i)
+		}
+	}
+	return good, rest
+}
+
+func (m *MultiClient) markFailed(i int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.status[i].Healthy = false
+	m.status[i].LastErr = err
+}
+
+// failover calls fn on each candidate in turn until one answers. Not-found and permanent
+// errors are answers and stop the failover; transient errors and ErrUnsupported move on.
+func (m *MultiClient) failover(fn func(ChainClient) error) error {
+	var errs []error
+	for _, i := range m.candidates() {
+		err := Classify(fn(m.providers[i].Client))
+		if err == nil || (!IsTransient(err) && !errors.Is(err, ErrUnsupported)) {
+			return err
+		}
+		if IsTransient(err) {
+			m.markFailed(i, err)
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", m.providers[i].Name, err))
+	}
+	if len(errs) == 0 {
+		return ErrUnsupported
+	}
+	return errors.Join(errs...)
+}
+
+func (m *MultiClient) Confirmations(ctx context.Context, txBlockNumber uint64) (conf uint64, err error) {
+	err = m.failover(func(c ChainClient) (err error) {
+		conf, err = c.Confirmations(ctx, txBlockNumber)
+		return err
+	})
+	return conf, err
+}
+
+type receiptAnswer struct {
+	txBlock, conf uint64
+	blockHash     string
+	found         bool
+	reverted      bool
+}
+
+// ConfirmationsFromTxHash fails over between providers, or with a quorum above 1 asks
+// every healthy, up to date provider and returns the answer that at least quorum of them
+// agree on. Not found is weaker evidence than a receipt, since a provider may simply not
+// have the transaction yet: it is only returned once every provider asked reports it.
+// Without agreement it returns a transient ErrNoQuorum so the deposit is left untouched.
+func (m *MultiClient) ConfirmationsFromTxHash(ctx context.Context, txHash string) (uint64, uint64, string, bool, bool, error) {
+	if m.quorum <= 1 {
+		a, err := m.receiptFailover(ctx, txHash)
+		return a.txBlock, a.conf, a.blockHash, a.found, a.reverted, err
+	}
+
+	noQuorum := &TransientError{Err: fmt.Errorf("%w for %s (quorum %d)", ErrNoQuorum, txHash, m.quorum)}
+	idx, _ := m.partition()
+	if len(idx) < m.quorum {
+		return 0, 0, "", false, false, noQuorum
+	}
+	answers := make([]receiptAnswer, len(idx))
+	errs := make([]error, len(idx))
+	var wg sync.WaitGroup
+	for j, i := range idx {
+		wg.Add(1)
+		go func(j int, c ChainClient) {
+			defer wg.Done()
+			a := &answers[j]
+			a.txBlock, a.conf, a.blockHash, a.found, a.reverted, errs[j] = c.ConfirmationsFromTxHash(ctx, txHash)
+		}(j, m.providers[i].Client)
+	}
+	wg.Wait()
+
+	// the quorum is a majority, so at most one block hash can reach it
+	votes := make(map[string][]receiptAnswer)
+	notFound := 0
+	for j, a := range answers {
+		switch {
+		case errs[j] != nil:
+			if IsTransient(Classify(errs[j])) {
+				m.markFailed(idx[j], errs[j])
+			}
+		case a.found:
+			votes[a.blockHash] = append(votes[a.blockHash], a)
+		default:
+			notFound++
+		}
+	}
+	for _, agreeing := range votes {
+		if len(agreeing) < m.quorum {
+			continue
+		}
+		// be conservative: report the lowest confirmation count among the agreeing providers
+		res := agreeing[0]
+		for _, a := range agreeing[1:] {
+			if a.conf < res.conf {
+				res = a
+			}
+		}
+		return res.txBlock, res.conf, res.blockHash, res.found, res.reverted, nil
+	}
+	if notFound == len(idx) {
+		return 0, 0, "", false, false, nil
+	}
+	return 0, 0, "", false, false, noQuorum
+}
+
+// receiptFailover asks the candidates in turn until one has the receipt. A provider that
+// reports the transaction as not found is failed over like an error, and not found is only
+// returned when no provider has it and none of them failed transiently.
+func (m *MultiClient) receiptFailover(ctx context.Context, txHash string) (receiptAnswer, error) {
+	var errs []error
+	notFound, transient := false, false
+	for _, i := range m.candidates() {
+		var a receiptAnswer
+		var err error
+		a.txBlock, a.conf, a.blockHash, a.found, a.reverted, err = m.providers[i].Client.ConfirmationsFromTxHash(ctx, txHash)
+		err = Classify(err)
+		switch {
+		case err == nil && a.found:
+			return a, nil
+		case err == nil:
+			notFound = true
+			continue
+		case IsTransient(err):
+			transient = true
+			m.markFailed(i, err)
+		case !errors.Is(err, ErrUnsupported):
+			return receiptAnswer{}, err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", m.providers[i].Name, err))
+	}
+	if notFound && !transient {
+		return receiptAnswer{}, nil
+	}
+	if len(errs) == 0 {
+		return receiptAnswer{}, ErrUnsupported
+	}
+	return receiptAnswer{}, errors.Join(errs...)
+}
+
+// Receipts fails over between providers like receiptFailover, per hash: the hashes a
+// provider has no receipt for are asked of the next one. A hash maps to nil only when no
+// provider has it and none failed on it; hashes some provider failed on and none had are
+// left out of the result, so they are retried next round. Quorum is not applied to
+// batches; with a quorum configured, callers should use ConfirmationsFromTxHash, so this
+// reports ErrUnsupported.
+func (m *MultiClient) Receipts(ctx context.Context, txHashes []string) (map[string]*Receipt, error) {
+	if m.quorum > 1 {
+		return nil, ErrUnsupported
+	}
+	res := make(map[string]*Receipt, len(txHashes))
+	missing := txHashes
+	unknown := make(map[string]bool) // hashes some provider could not answer for
+	asked := false
+	var errs []error
+	for _, i := range m.candidates() {
+		if len(missing) == 0 {
+			break
+		}
+		src, ok := m.providers[i].Client.(ReceiptBatcher)
+		if !ok {
+			continue
+		}
+		recs, err := src.Receipts(ctx, missing)
+		err = Classify(err)
+		switch {
+		case err == nil:
+		case IsTransient(err):
+			m.markFailed(i, err)
+			for _, h := range missing {
+				unknown[h] = true
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", m.providers[i].Name, err))
+			continue
+		case errors.Is(err, ErrUnsupported):
+			continue
+		default:
+			return nil, err
+		}
+		asked = true
+		var next []string
+		for _, h := range missing {
+			r, ok := recs[h]
+			switch {
+			case r != nil:
+				res[h] = r
+			case !ok:
+				unknown[h] = true
+				next = append(next, h)
+			default:
+				next = append(next, h)
+			}
+		}
+		missing = next
+	}
+	if !asked {
+		if len(errs) == 0 {
+			return nil, ErrUnsupported
+		}
+		return nil, errors.Join(errs...)
+	}
+	for _, h := range missing {
+		if !unknown[h] {
+			res[h] = nil
+		}
+	}
+	return res, nil
+}
+
+func (m *MultiClient) HeaderByNumber(ctx context.Context, number uint64) (h *Header, err error) {
+	err = m.failover(func(c ChainClient) error {
+		src, ok := c.(HeaderSource)
+		if !ok {
+			return ErrUnsupported
+		}
+		h, err = src.HeaderByNumber(ctx, number)
+		return err
+	})
+	return h, err
+}
+
+func (m *MultiClient) TaggedHeader(ctx context.Context, tag string) (h *Header, err error) {
+	err = m.failover(func(c ChainClient) error {
+		src, ok := c.(TaggedHeadSource)
+		if !ok {
+			return ErrUnsupported
+		}
+		h, err = src.TaggedHeader(ctx, tag)
+		return err
+	})
+	return h, err
+}
+
+func (m *MultiClient) BlockByNumber(ctx context.Context, number uint64) (b *Block, err error) {
+	err = m.failover(func(c ChainClient) error {
+		src, ok := c.(BlockSource)
+		if !ok {
+			return ErrUnsupported
+		}
+		b, err = src.BlockByNumber(ctx, number)
+		return err
+	})
+	return b, err
+}
+
+func (m *MultiClient) TransferLogs(ctx context.Context, from, to uint64, tokens []string) (logs []TransferLog, err error) {
+	err = m.failover(func(c ChainClient) error {
+		src, ok := c.(LogSource)
+		if !ok {
+			return ErrUnsupported
+		}
+		logs, err = src.TransferLogs(ctx, from, to, tokens)
+		return err
+	})
+	return logs, err
+}
+
+func (m *MultiClient) InternalTransfers(ctx context.Context, number uint64, hash string) (res []InternalTransfer, err error) {
+	err = m.failover(func(c ChainClient) error {
+		src, ok := c.(TraceSource)
+		if !ok {
+			return ErrUnsupported
+		}
+		res, err = src.InternalTransfers(ctx, number, hash)
+		return err
+	})
+	return res, err
+}
+
+func (m *MultiClient) SubscribeNewHeads(ctx context.Context, ch chan<- Header) (sub Subscription, err error) {
+	err = m.failover(func(c ChainClient) error {
+		src, ok := c.(HeadSubscriber)
+		if !ok {
+			return ErrUnsupported
+		}
+		sub, err = src.SubscribeNewHeads(ctx, ch)
+		return err
+	})
+	return sub, err
+}
@@ -0,0 +1,197 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func mockWithTx(head uint64, txBlock uint64, hash string) *MockClient {
+	m := NewMock()
+	m.Block = head
+	m.TxInfo["0xabc"] = struct {
+		Block    uint64
+		Hash     string
+		Reverted bool
+	}{Block: txBlock, Hash: hash}
+	return m
+}
+
+func newMulti(t *testing.T, providers []Provider, maxLag uint64, quorum int) *MultiClient {
+	t.Helper()
+	m, err := NewMulti(providers, maxLag, quorum)
+	if err != nil {
+		t.Fatalf("NewMulti: %v", err)
+	}
+	return m
+}
+
+func TestMulti_FailsOverOnTransientError(t *testing.T) {
+	bad := &flakyClient{MockClient: *mockWithTx(100, 90, "0xh"), failures: 1, err: context.DeadlineExceeded}
+	good := mockWithTx(100, 90, "0xh")
+	m := newMulti(t, []Provider{{Name: "bad", Client: bad}, {Name: "good", Client: good}}, 0, 0)
+
+	_, conf, hash, found, _, err := m.ConfirmationsFromTxHash(context.Background(), "0xabc")
+	if err != nil || !found || hash != "0xh" || conf != 11 {
+		t.Fatalf("unexpected answer conf=%d hash=%s found=%v err=%v", conf, hash, found, err)
+	}
+	if h := m.Health(); h[0].Healthy || !h[1].Healthy {
+		t.Fatalf("expected bad provider marked unhealthy: %+v", h)
+	}
+}
+
+func TestMulti_AvoidsLaggingProvider(t *testing.T) {
+	lagging := mockWithTx(90, 90, "0xstale")
+	current := mockWithTx(100, 90, "0xh")
+	m := newMulti(t, []Provider{{Name: "lagging", Client: lagging}, {Name: "current", Client: current}}, 3, 0)
+
+	head, err := m.BlockNumber(context.Background())
+	if err != nil || head != 100 {
+		t.Fatalf("expected best head 100, got %d err=%v", head, err)
+	}
+	if !m.Health()[0].Lagging {
+		t.Fatalf("expected first provider to be lagging")
+	}
+	if _, _, hash, _, _, _ := m.ConfirmationsFromTxHash(context.Background(), "0xabc"); hash != "0xh" {
+		t.Fatalf("expected answer from the current provider, got %s", hash)
+	}
+}
+
+func TestMulti_QuorumOnReceiptBlockHash(t *testing.T) {
+	providers := []Provider{
+		{Name: "a", Client: mockWithTx(100, 90, "0xh1")},
+		{Name: "b", Client: mockWithTx(101, 90, "0xh1")},
+		{Name: "c", Client: mockWithTx(100, 90, "0xh2")},
+	}
+
+	_, conf, hash, found, _, err := newMulti(t, providers, 0, 2).ConfirmationsFromTxHash(context.Background(), "0xabc")
+	if err != nil || !found || hash != "0xh1" || conf != 11 {
+		t.Fatalf("expected quorum on 0xh1 with lowest confirmations, got conf=%d hash=%s err=%v", conf, hash, err)
+	}
+
+	_, _, _, _, _, err = newMulti(t, providers, 0, 3).ConfirmationsFromTxHash(context.Background(), "0xabc")
+	if !errors.Is(err, ErrNoQuorum) || !IsTransient(err) {
+		t.Fatalf("expected transient ErrNoQuorum, got %v", err)
+	}
+}
+
+func TestNewMulti_RejectsMinorityQuorum(t *testing.T) {
+	providers := []Provider{{Name: "a", Client: NewMock()}, {Name: "b", Client: NewMock()}, {Name: "c", Client: NewMock()}, {Name: "d", Client: NewMock()}}
+	for _, q := range []int{2, 5} {
+		if _, err := NewMulti(providers, 0, q); err == nil {
+			t.Fatalf("expected quorum %d of 4 to be rejected", q)
+		}
+	}
+	if _, err := NewMulti(providers, 0, 3); err != nil {
+		t.Fatalf("expected quorum 3 of 4 to be accepted: %v", err)
+	}
+}
+
+func TestMulti_NotFoundNeedsEveryProvider(t *testing.T) {
+	missing := NewMock()
+	missing.Block = 100
+	providers := []Provider{
+		{Name: "a", Client: missing},
+		{Name: "b", Client: missing},
+		{Name: "c", Client: mockWithTx(100, 90, "0xh")},
+	}
+
+	// a quorum of not found is not enough while one provider has the receipt
+	_, _, _, found, _, err := newMulti(t, providers, 0, 2).ConfirmationsFromTxHash(context.Background(), "0xabc")
+	if found || !errors.Is(err, ErrNoQuorum) {
+		t.Fatalf("expected ErrNoQuorum, got found=%v err=%v", found, err)
+	}
+
+	// failover moves past a provider that does not have the transaction
+	_, _, hash, found, _, err := newMulti(t, providers, 0, 0).ConfirmationsFromTxHash(context.Background(), "0xabc")
+	if err != nil || !found || hash != "0xh" {
+		t.Fatalf("expected the receipt from the third provider, got hash=%s found=%v err=%v", hash, found, err)
+	}
+
+	// and reports not found only when no provider has it
+	providers[2].Client = missing
+	_, _, _, found, _, err = newMulti(t, providers, 0, 2).ConfirmationsFromTxHash(context.Background(), "0xabc")
+	if err != nil || found {
+		t.Fatalf("expected not found, got found=%v err=%v", found, err)
+	}
+}
+
+// downClient fails its head probe and counts the receipt lookups it is still asked for.
+type downClient struct {
+	MockClient
+	lookups int
+}
+
+func (d *downClient) BlockNumber(ctx context.Context) (uint64, error) {
+	return 0, context.DeadlineExceeded
+}
+
+func (d *downClient) ConfirmationsFromTxHash(ctx context.Context, txHash string) (uint64, uint64, string, bool, bool, error) {
+	d.lookups++
+	return d.MockClient.ConfirmationsFromTxHash(ctx, txHash)
+}
+
+func TestMulti_QuorumSkipsUnhealthyProviders(t *testing.T) {
+	down := &downClient{MockClient: *mockWithTx(100, 90, "0xother")}
+	providers := []Provider{
+		{Name: "down", Client: down},
+		{Name: "b", Client: mockWithTx(100, 90, "0xh")},
+		{Name: "c", Client: mockWithTx(100, 90, "0xh")},
+	}
+	m := newMulti(t, providers, 0, 2)
+	if _, err := m.BlockNumber(context.Background()); err != nil {
+		t.Fatalf("BlockNumber: %v", err)
+	}
+	if _, _, hash, _, _, err := m.ConfirmationsFromTxHash(context.Background(), "0xabc"); err != nil || hash != "0xh" {
+		t.Fatalf("expected quorum from the healthy providers, got hash=%s err=%v", hash, err)
+	}
+	if down.lookups != 0 {
+		t.Fatalf("expected the unhealthy provider not to be asked, got %d lookups", down.lookups)
+	}
+}
+
+// batchDownClient fails every receipt batch transiently.
+type batchDownClient struct {
+	MockClient
+}
+
+func (b *batchDownClient) Receipts(ctx context.Context, txHashes []string) (map[string]*Receipt, error) {
+	return nil, context.DeadlineExceeded
+}
+
+// A provider that has not indexed a transaction yet must not make it look dropped: the
+// hashes missing from its batch are asked of the next provider.
+func TestMulti_ReceiptsFailOverOnMissingEntries(t *testing.T) {
+	behind := mockWithTx(100, 90, "0xh")
+	ahead := mockWithTx(100, 90, "0xh")
+	ahead.TxInfo["0xdef"] = struct {
+		Block    uint64
+		Hash     string
+		Reverted bool
+	}{Block: 95, Hash: "0xh95"}
+	m := newMulti(t, []Provider{{Name: "behind", Client: behind}, {Name: "ahead", Client: ahead}}, 0, 0)
+
+	recs, err := m.Receipts(context.Background(), []string{"0xabc", "0xdef", "0xnone"})
+	if err != nil {
+		t.Fatalf("Receipts: %v", err)
+	}
+	if recs["0xabc"] == nil || recs["0xdef"] == nil || recs["0xdef"].BlockHash != "0xh95" {
+		t.Fatalf("expected receipts for 0xabc and 0xdef, got %+v", recs)
+	}
+	if r, ok := recs["0xnone"]; !ok || r != nil {
+		t.Fatalf("expected 0xnone reported missing by every provider, got %+v (present %v)", r, ok)
+	}
+
+	// the only other provider failed, so a missing receipt is unknown rather than absent
+	m = newMulti(t, []Provider{{Name: "behind", Client: behind}, {Name: "down", Client: &batchDownClient{MockClient: *ahead}}}, 0, 0)
+	recs, err = m.Receipts(context.Background(), []string{"0xabc", "0xdef"})
+	if err != nil {
+		t.Fatalf("Receipts: %v", err)
+	}
+	if recs["0xabc"] == nil {
+		t.Fatalf("expected a receipt for 0xabc, got %+v", recs)
+	}
+	if r, ok := recs["0xdef"]; ok {
+		t.Fatalf("expected 0xdef left out after a transient failure, got %+v", r)
+	}
+}
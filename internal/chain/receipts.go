@@ -0,0 +1,21 @@
+package chain
+
+import "context"
+
+// receiptBatchSize caps how many receipt lookups go into one JSON-RPC batch request.
+const receiptBatchSize = 100
+
+// Receipt is the part of a transaction receipt needed to judge a deposit.
+type Receipt struct {
+	TxHash      string
+	BlockNumber uint64
+	BlockHash   string
+	Reverted    bool
+}
+
+// ReceiptBatcher is implemented by clients that can fetch many receipts in one round trip.
+// In the result a hash maps to nil when the node reports no receipt for it; hashes whose
+// lookup failed are absent, meaning "unknown this round".
+type ReceiptBatcher interface {
+	Receipts(ctx context.Context, txHashes []string) (map[string]*Receipt, error)
+}
@@ -0,0 +1,359 @@
+package chain
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// ErrNotRecorded is returned by a ReplayClient for a call the fixture has no (further)
+// response for.
+var ErrNotRecorded = errors.New("chain: call not recorded")
+
+// Entry is one recorded call: the method, its arguments and either its result or its
+// error. A fixture is a file of entries, one JSON object per line, in call order.
+type Entry struct {
+	Method string          `json:"method"`
+	Args   json.RawMessage `json:"args"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *RecordedError  `json:"error,omitempty"`
+}
+
+// RecordedError keeps an error's text and how the engine would classify it, so replayed
+// errors take the same retry, fallback and not-found paths as the originals.
+type RecordedError struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// error kinds, checked in this order
+var errorKinds = []struct {
+	kind  string
+	match func(error) bool
+	cause func(msg string) error
+}{
+	{"not_found", func(err error) bool { return errors.Is(err, ErrNotFound) }, func(string) error { return ErrNotFound }},
+	{"unsupported", func(err error) bool { return errors.Is(err, ErrUnsupported) }, func(string) error { return ErrUnsupported }},
+	{"circuit_open", func(err error) bool { return errors.Is(err, ErrCircuitOpen) }, func(string) error { return ErrCircuitOpen }},
+	{"no_quorum", func(err error) bool { return errors.Is(err, ErrNoQuorum) }, func(string) error { return ErrNoQuorum }},
+	{"canceled", func(err error) bool { return errors.Is(err, context.Canceled) }, func(string) error { return context.Canceled }},
+	{"deadline", func(err error) bool { return errors.Is(err, context.DeadlineExceeded) }, func(string) error { return context.DeadlineExceeded }},
+	{"transient", IsTransient, func(msg string) error { return &TransientError{Err: errors.New(msg)} }},
+	{"permanent", func(err error) bool { var pe *PermanentError; return errors.As(err, &pe) }, func(msg string) error { return &PermanentError{Err: errors.New(msg)} }},
+}
+
+func recordError(err error) *RecordedError {
+	if err == nil {
+		return nil
+	}
+	for _, k := range errorKinds {
+		if k.match(err) {
+			return &RecordedError{Kind: k.kind, Message: err.Error()}
+		}
+	}
+	return &RecordedError{Message: err.Error()}
+}
+
+// Err rebuilds the error: its text is the original's and errors.Is/As see the same
+// classification.
+func (e *RecordedError) Err() error {
+	for _, k := range errorKinds {
+		if k.kind == e.Kind {
+			return &replayedError{msg: e.Message, cause: k.cause(e.Message)}
+		}
+	}
+	return errors.New(e.Message)
+}
+
+type replayedError struct {
+	msg   string
+	cause error
+}
+
+func (e *replayedError) Error() string { return e.msg }
+func (e *replayedError) Unwrap() error { return e.cause }
+
+// confirmationsResult holds the results of ConfirmationsFromTxHash.
+type confirmationsResult struct {
+	TxBlock       uint64 `json:"txBlock"`
+	Confirmations uint64 `json:"confirmations"`
+	BlockHash     string `json:"blockHash"`
+	Found         bool   `json:"found"`
+	Reverted      bool   `json:"reverted"`
+}
+
+// Recorder decorates a ChainClient and writes every call, with its arguments and its
+// result or error, to a fixture that a ReplayClient can serve back. Capabilities the
+// wrapped client lacks are recorded as ErrUnsupported. Head subscriptions cannot be
+// replayed, so a recording engine polls instead.
+type Recorder struct {
+	inner ChainClient
+	mu    sync.Mutex
+	w     io.Writer
+	err   error
+}
+
+// NewRecorder records inner's calls to w.
+func NewRecorder(inner ChainClient, w io.Writer) *Recorder {
+	return &Recorder{inner: inner, w: w}
+}
+
+// Err returns the first error writing the fixture, if any. Write failures never fail the
+// recorded call.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) write(method string, args []interface{}, result interface{}, err error) {
+	e := Entry{Method: method, Error: recordError(err)}
+	var merr error
+	if e.Args, merr = json.Marshal(args); merr == nil && err == nil {
+		e.Result, merr = json.Marshal(result)
+	}
+	var line []byte
+	if merr == nil {
+		line, merr = json.Marshal(e)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	if merr != nil {
+		r.err = fmt.Errorf("record %s: %w", method, merr)
+		return
+	}
+	if _, werr := r.w.Write(append(line, '\n')); werr != nil {
+		r.err = fmt.Errorf("record %s: %w", method, werr)
+	}
+}
+
+// record runs call and writes it to the fixture.
+func record[T any](r *Recorder, method string, args []interface{}, call func() (T, error)) (T, error) {
+	res, err := call()
+	r.write(method, args, res, err)
+	return res, err
+}
+
+func (r *Recorder) BlockNumber(ctx context.Context) (uint64, error) {
+	return record(r, "BlockNumber", nil, func() (uint64, error) { return r.inner.BlockNumber(ctx) })
+}
+
+func (r *Recorder) Confirmations(ctx context.Context, txBlockNumber uint64) (uint64, error) {
+	return record(r, "Confirmations", []interface{}{txBlockNumber}, func() (uint64, error) {
+		return r.inner.Confirmations(ctx, txBlockNumber)
+	})
+}
+
+func (r *Recorder) ConfirmationsFromTxHash(ctx context.Context, txHash string) (uint64, uint64, string, bool, bool, error) {
+	res, err := record(r, "ConfirmationsFromTxHash", []interface{}{txHash}, func() (res confirmationsResult, err error) {
+		res.TxBlock, res.Confirmations, res.BlockHash, res.Found, res.Reverted, err = r.inner.ConfirmationsFromTxHash(ctx, txHash)
+		return res, err
+	})
+	return res.TxBlock, res.Confirmations, res.BlockHash, res.Found, res.Reverted, err
+}
+
+func (r *Recorder) HeaderByNumber(ctx context.Context, number uint64) (*Header, error) {
+	return record(r, "HeaderByNumber", []interface{}{number}, func() (*Header, error) {
+		src, ok := r.inner.(HeaderSource)
+		if !ok {
+			return nil, ErrUnsupported
+		}
+		return src.HeaderByNumber(ctx, number)
+	})
+}
+
+func (r *Recorder) TaggedHeader(ctx context.Context, tag string) (*Header, error) {
+	return record(r, "TaggedHeader", []interface{}{tag}, func() (*Header, error) {
+		src, ok := r.inner.(TaggedHeadSource)
+		if !ok {
+			return nil, ErrUnsupported
+		}
+		return src.TaggedHeader(ctx, tag)
+	})
+}
+
+func (r *Recorder) BlockByNumber(ctx context.Context, number uint64) (*Block, error) {
+	return record(r, "BlockByNumber", []interface{}{number}, func() (*Block, error) {
+		src, ok := r.inner.(BlockSource)
+		if !ok {
+			return nil, ErrUnsupported
+		}
+		return src.BlockByNumber(ctx, number)
+	})
+}
+
+func (r *Recorder) TransferLogs(ctx context.Context, from, to uint64, tokens []string) ([]TransferLog, error) {
+	return record(r, "TransferLogs", []interface{}{from, to, tokens}, func() ([]TransferLog, error) {
+		src, ok := r.inner.(LogSource)
+		if !ok {
+			return nil, ErrUnsupported
+		}
+		return src.TransferLogs(ctx, from, to, tokens)
+	})
+}
+
+func (r *Recorder) Receipts(ctx context.Context, txHashes []string) (map[string]*Receipt, error) {
+	return record(r, "Receipts", []interface{}{txHashes}, func() (map[string]*Receipt, error) {
+		src, ok := r.inner.(ReceiptBatcher)
+		if !ok {
+			return nil, ErrUnsupported
+		}
+		return src.Receipts(ctx, txHashes)
+	})
+}
+
+func (r *Recorder) InternalTransfers(ctx context.Context, number uint64, hash string) ([]InternalTransfer, error) {
+	return record(r, "InternalTransfers", []interface{}{number, hash}, func() ([]InternalTransfer, error) {
+		src, ok := r.inner.(TraceSource)
+		if !ok {
+			return nil, ErrUnsupported
+		}
+		return src.InternalTransfers(ctx, number, hash)
+	})
+}
+
+// SubscribeNewHeads is never passed through: pushed heads cannot be replayed in order
+// with the calls around them.
+func (r *Recorder) SubscribeNewHeads(ctx context.Context, ch chan<- Header) (Subscription, error) {
+	return nil, ErrUnsupported
+}
+
+// ReplayClient serves a recorded fixture back as a ChainClient. Each call gets the next
+// unused response recorded for the same method and arguments, so a run that makes the
+// same calls as the recorded one sees the same answers in the same order, independent of
+// wall-clock time or the network. Calls beyond the fixture fail with ErrNotRecorded.
+type ReplayClient struct {
+	mu      sync.Mutex
+	entries map[string][]Entry
+}
+
+// NewReplay reads a fixture written by a Recorder.
+func NewReplay(r io.Reader) (*ReplayClient, error) {
+	p := &ReplayClient{entries: make(map[string][]Entry)}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 64<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("replay: line %d: %w", line, err)
+		}
+		k := replayKey(e.Method, e.Args)
+		p.entries[k] = append(p.entries[k], e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("replay: %w", err)
+	}
+	return p, nil
+}
+
+// LoadReplay opens the fixture file at path.
+func LoadReplay(path string) (*ReplayClient, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return NewReplay(f)
+}
+
+// Remaining returns how many recorded responses have not been served yet.
+func (p *ReplayClient) Remaining() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, es := range p.entries {
+		n += len(es)
+	}
+	return n
+}
+
+func replayKey(method string, args json.RawMessage) string {
+	if len(args) == 0 {
+		args = json.RawMessage("null")
+	}
+	return method + string(args)
+}
+
+// replay serves the next response recorded for method and args.
+func replay[T any](p *ReplayClient, method string, args ...interface{}) (T, error) {
+	var res T
+	raw, err := json.Marshal(args)
+	if err != nil {
+		return res, &PermanentError{Err: err}
+	}
+	if args == nil {
+		raw = json.RawMessage("null")
+	}
+	k := replayKey(method, raw)
+
+	p.mu.Lock()
+	es := p.entries[k]
+	if len(es) == 0 {
+		p.mu.Unlock()
+		return res, &PermanentError{Err: fmt.Errorf("%s%s: %w", method, raw, ErrNotRecorded)}
+	}
+	e := es[0]
+	p.entries[k] = es[1:]
+	p.mu.Unlock()
+
+	if e.Error != nil {
+		return res, e.Error.Err()
+	}
+	if err := json.Unmarshal(e.Result, &res); err != nil {
+		return res, &PermanentError{Err: fmt.Errorf("replay %s: %w", method, err)}
+	}
+	return res, nil
+}
+
+func (p *ReplayClient) BlockNumber(ctx context.Context) (uint64, error) {
+	return replay[uint64](p, "BlockNumber")
+}
+
+func (p *ReplayClient) Confirmations(ctx context.Context, txBlockNumber uint64) (uint64, error) {
+	return replay[uint64](p, "Confirmations", txBlockNumber)
+}
+
+func (p *ReplayClient) ConfirmationsFromTxHash(ctx context.Context, txHash string) (uint64, uint64, string, bool, bool, error) {
+	res, err := replay[confirmationsResult](p, "ConfirmationsFromTxHash", txHash)
+	return res.TxBlock, res.Confirmations, res.BlockHash, res.Found, res.Reverted, err
+}
+
+func (p *ReplayClient) HeaderByNumber(ctx context.Context, number uint64) (*Header, error) {
+	return replay[*Header](p, "HeaderByNumber", number)
+}
+
+func (p *ReplayClient) TaggedHeader(ctx context.Context, tag string) (*Header, error) {
+	return replay[*Header](p, "TaggedHeader", tag)
+}
+
+func (p *ReplayClient) BlockByNumber(ctx context.Context, number uint64) (*Block, error) {
+	return replay[*Block](p, "BlockByNumber", number)
+}
+
+func (p *ReplayClient) TransferLogs(ctx context.Context, from, to uint64, tokens []string) ([]TransferLog, error) {
+	return replay[[]TransferLog](p, "TransferLogs", from, to, tokens)
+}
+
+func (p *ReplayClient) Receipts(ctx context.Context, txHashes []string) (map[string]*Receipt, error) {
+	return replay[map[string]*Receipt](p, "Receipts", txHashes)
+}
+
+func (p *ReplayClient) InternalTransfers(ctx context.Context, number uint64, hash string) ([]InternalTransfer, error) {
+	return replay[[]InternalTransfer](p, "InternalTransfers", number, hash)
+}
+
+func (p *ReplayClient) SubscribeNewHeads(ctx context.Context, ch chan<- Header) (Subscription, error) {
+	return nil, ErrUnsupported
+}
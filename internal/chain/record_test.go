@@ -0,0 +1,89 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestRecorder_ReplaysSession(t *testing.T) {
+	m := NewMock()
+	m.Block = 120
+	m.TxInfo["0xabc"] = struct {
+		Block    uint64
+		Hash     string
+		Reverted bool
+	}{Block: 110, Hash: "0xb110"}
+	m.Blocks[110] = &Block{Header: Header{Number: 110, Hash: "0xb110", ParentHash: "0xb109"}, Txs: []Tx{{Hash: "0xabc", To: "0xa1", Value: big.NewInt(5)}}}
+	m.Logs = []TransferLog{{TxHash: "0xt", LogIndex: 2, BlockNumber: 110, BlockHash: "0xb110", Token: "0xusdc", To: "0xa1", Value: big.NewInt(7)}}
+	flaky := &flakyClient{MockClient: *m, failures: 1, err: &TransientError{Err: errors.New("502 bad gateway")}}
+
+	var fixture bytes.Buffer
+	rec := NewRecorder(flaky, &fixture)
+	ctx := context.Background()
+
+	// session runs the same calls against c and returns what it saw
+	session := func(c ChainClient) []interface{} {
+		var out []interface{}
+		add := func(vals ...interface{}) { out = append(out, vals...) }
+		add(c.BlockNumber(ctx))
+		add(c.ConfirmationsFromTxHash(ctx, "0xabc"))
+		add(c.ConfirmationsFromTxHash(ctx, "0xabc"))
+		add(c.ConfirmationsFromTxHash(ctx, "0xmissing"))
+		add(c.(BlockSource).BlockByNumber(ctx, 110))
+		add(c.(LogSource).TransferLogs(ctx, 100, 120, []string{"0xusdc"}))
+		add(c.(ReceiptBatcher).Receipts(ctx, []string{"0xabc", "0xmissing"}))
+		_, err := c.(BlockSource).BlockByNumber(ctx, 111)
+		add(err.Error(), errors.Is(err, ErrNotFound))
+		return out
+	}
+	recorded := session(rec)
+	if err := rec.Err(); err != nil {
+		t.Fatalf("recording: %v", err)
+	}
+
+	rp, err := NewReplay(&fixture)
+	if err != nil {
+		t.Fatalf("NewReplay: %v", err)
+	}
+	replayed := session(rp)
+	for i := range recorded {
+		if e, ok := recorded[i].(error); ok {
+			// errors are rebuilt: same text, same classification
+			r, _ := replayed[i].(error)
+			if r == nil || r.Error() != e.Error() || IsTransient(r) != IsTransient(e) {
+				t.Fatalf("value %d: recorded error %v, replayed %v", i, e, replayed[i])
+			}
+			continue
+		}
+		if !reflect.DeepEqual(recorded[i], replayed[i]) {
+			t.Fatalf("value %d: recorded %#v, replayed %#v", i, recorded[i], replayed[i])
+		}
+	}
+	if n := rp.Remaining(); n != 0 {
+		t.Fatalf("expected every response to be served, %d left", n)
+	}
+
+	var pe *PermanentError
+	if _, err := rp.BlockNumber(ctx); !errors.Is(err, ErrNotRecorded) || !errors.As(err, &pe) {
+		t.Fatalf("expected a permanent ErrNotRecorded past the fixture, got %v", err)
+	}
+}
+
+func TestRecorder_RecordsMissingCapabilities(t *testing.T) {
+	var fixture bytes.Buffer
+	rec := NewRecorder(struct{ ChainClient }{NewMock()}, &fixture)
+	if _, err := rec.InternalTransfers(context.Background(), 1, "0x1"); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+	rp, err := NewReplay(&fixture)
+	if err != nil {
+		t.Fatalf("NewReplay: %v", err)
+	}
+	if _, err := rp.InternalTransfers(context.Background(), 1, "0x1"); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected replayed ErrUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package chain
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Registry holds one ChainClient per chain ID, so a single engine can follow several
+// chains (Ethereum, Polygon, Base, ...).
+type Registry struct {
+	clients map[uint64]ChainClient
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{clients: make(map[uint64]ChainClient)}
+}
+
+// Register adds the client for chainID. Each chain can be registered once.
+func (r *Registry) Register(chainID uint64, c ChainClient) error {
+	if _, ok := r.clients[chainID]; ok {
+		return fmt.Errorf("chain: chain %d already registered", chainID)
+	}
+	r.clients[chainID] = c
+	return nil
+}
+
+// Client returns the client registered for chainID.
+func (r *Registry) Client(chainID uint64) (ChainClient, bool) {
+	c, ok := r.clients[chainID]
+	return c, ok
+}
+
+// ChainIDs returns the registered chain IDs in ascending order.
+func (r *Registry) ChainIDs() []uint64 {
+	ids := make([]uint64, 0, len(r.clients))
+	for id := range r.clients {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
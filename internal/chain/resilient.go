@@ -0,0 +1,229 @@
+package chain
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RetryPolicy retries transient errors with capped exponential backoff and jitter.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy is a conservative policy suited to public RPC providers.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 4, BaseDelay: 200 * time.Millisecond, MaxDelay: 5 * time.Second}
+}
+
+// backoff returns the delay before retry number attempt (1-based): a random duration in
+// [d/2, d] where d doubles per attempt up to MaxDelay.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// CircuitBreaker opens after Threshold consecutive transient failures and rejects calls
+// with ErrCircuitOpen for Cooldown. After the cooldown one trial call is let through: a
+// success closes the breaker, a failure re-opens it.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// NewCircuitBreaker creates a closed breaker.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// Allow returns ErrCircuitOpen while the breaker is open.
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return nil
+	}
+	if b.trial || b.now().Sub(b.openedAt) < b.cooldown {
+		return ErrCircuitOpen
+	}
+	b.trial = true
+	return nil
+}
+
+// Record feeds a call result into the breaker. Only transient errors count as failures:
+// not-found and permanent errors are answers from a healthy node.
+func (b *CircuitBreaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if !IsTransient(err) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = b.now()
+	}
+}
+
+// ResilientClient decorates a ChainClient with a retry policy and a circuit breaker, so a
+// flaky RPC pauses processing instead of producing wrong answers. Optional capabilities of
+// the inner client are passed through; missing ones return ErrUnsupported.
+type ResilientClient struct {
+	inner   ChainClient
+	policy  RetryPolicy
+	breaker *CircuitBreaker
+}
+
+// NewResilient wraps inner. A nil breaker disables circuit breaking.
+func NewResilient(inner ChainClient, policy RetryPolicy, breaker *CircuitBreaker) *ResilientClient {
+	if breaker == nil {
+		breaker = NewCircuitBreaker(0, 0)
+	}
+	return &ResilientClient{inner: inner, policy: policy, breaker: breaker}
+}
+
+// do runs fn under the breaker, retrying transient errors per the policy.
+func (r *ResilientClient) do(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		if err := r.breaker.Allow(); err != nil {
+			return err
+		}
+		err := Classify(fn())
+		r.breaker.Record(err)
+		if err == nil || !IsTransient(err) || attempt >= r.policy.MaxAttempts {
+			return err
+		}
+		t := time.NewTimer(r.policy.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+func (r *ResilientClient) BlockNumber(ctx context.Context) (n uint64, err error) {
+	err = r.do(ctx, func() (err error) {
+		n, err = r.inner.BlockNumber(ctx)
+		return err
+	})
+	return n, err
+}
+
+func (r *ResilientClient) Confirmations(ctx context.Context, txBlockNumber uint64) (conf uint64, err error) {
+	err = r.do(ctx, func() (err error) {
+		conf, err = r.inner.Confirmations(ctx, txBlockNumber)
+		return err
+	})
+	return conf, err
+}
+
+func (r *ResilientClient) ConfirmationsFromTxHash(ctx context.Context, txHash string) (txBlock uint64, confirmations uint64, blockHash string, found bool, reverted bool, err error) {
+	err = r.do(ctx, func() (err error) {
+		txBlock, confirmations, blockHash, found, reverted, err = r.inner.ConfirmationsFromTxHash(ctx, txHash)
+		return err
+	})
+	return txBlock, confirmations, blockHash, found, reverted, err
+}
+
+func (r *ResilientClient) HeaderByNumber(ctx context.Context, number uint64) (h *Header, err error) {
+	src, ok := r.inner.(HeaderSource)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	err = r.do(ctx, func() (err error) {
+		h, err = src.HeaderByNumber(ctx, number)
+		return err
+	})
+	return h, err
+}
+
+func (r *ResilientClient) TaggedHeader(ctx context.Context, tag string) (h *Header, err error) {
+	src, ok := r.inner.(TaggedHeadSource)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	err = r.do(ctx, func() (err error) {
+		h, err = src.TaggedHeader(ctx, tag)
+		return err
+	})
+	return h, err
+}
+
+func (r *ResilientClient) BlockByNumber(ctx context.Context, number uint64) (b *Block, err error) {
+	src, ok := r.inner.(BlockSource)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	err = r.do(ctx, func() (err error) {
+		b, err = src.BlockByNumber(ctx, number)
+		return err
+	})
+	return b, err
+}
+
+func (r *ResilientClient) TransferLogs(ctx context.Context, from, to uint64, tokens []string) (logs []TransferLog, err error) {
+	src, ok := r.inner.(LogSource)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	err = r.do(ctx, func() (err error) {
+		logs, err = src.TransferLogs(ctx, from, to, tokens)
+		return err
+	})
+	return logs, err
+}
+
+func (r *ResilientClient) Receipts(ctx context.Context, txHashes []string) (recs map[string]*Receipt, err error) {
+	src, ok := r.inner.(ReceiptBatcher)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	err = r.do(ctx, func() (err error) {
+		recs, err = src.Receipts(ctx, txHashes)
+		return err
+	})
+	return recs, err
+}
+
+func (r *ResilientClient) InternalTransfers(ctx context.Context, number uint64, hash string) (res []InternalTransfer, err error) {
+	src, ok := r.inner.(TraceSource)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	err = r.do(ctx, func() (err error) {
+		res, err = src.InternalTransfers(ctx, number, hash)
+		return err
+	})
+	return res, err
+}
+
+// SubscribeNewHeads is passed through without retries; the engine already falls back to
+// polling and resubscribes on its own schedule.
+func (r *ResilientClient) SubscribeNewHeads(ctx context.Context, ch chan<- Header) (Subscription, error) {
+	src, ok := r.inner.(HeadSubscriber)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return src.SubscribeNewHeads(ctx, ch)
+}
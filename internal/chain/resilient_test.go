@@ -0,0 +1,91 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// flakyClient fails the first failures calls to ConfirmationsFromTxHash with err.
+type flakyClient struct {
+	MockClient
+	failures int
+	err      error
+	calls    int
+}
+
+func (f *flakyClient) ConfirmationsFromTxHash(ctx context.Context, txHash string) (uint64, uint64, string, bool, bool, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return 0, 0, "", false, false, f.err
+	}
+	return f.MockClient.ConfirmationsFromTxHash(ctx, txHash)
+}
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		name      string
+		err       error
+		notFound  bool
+		transient bool
+	}{
+		{"not found", ethereum.NotFound, true, false},
+		{"timeout", context.DeadlineExceeded, false, true},
+		{"rate limited", rpc.HTTPError{StatusCode: 429}, false, true},
+		{"bad request", rpc.HTTPError{StatusCode: 400}, false, false},
+	}
+	for _, c := range cases {
+		err := Classify(c.err)
+		if errors.Is(err, ErrNotFound) != c.notFound || IsTransient(err) != c.transient {
+			t.Fatalf("%s: unexpected classification %v", c.name, err)
+		}
+	}
+}
+
+func TestResilient_RetriesTransientErrors(t *testing.T) {
+	f := &flakyClient{MockClient: *NewMock(), failures: 2, err: rpc.HTTPError{StatusCode: 503}}
+	f.Block = 100
+	f.TxInfo["0xabc"] = struct {
+		Block    uint64
+		Hash     string
+		Reverted bool
+	}{Block: 90, Hash: "0xhash"}
+
+	r := NewResilient(f, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}, nil)
+	_, _, _, found, _, err := r.ConfirmationsFromTxHash(context.Background(), "0xabc")
+	if err != nil || !found {
+		t.Fatalf("expected success after retries, found=%v err=%v", found, err)
+	}
+	if f.calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", f.calls)
+	}
+}
+
+func TestResilient_BreakerOpensAndRecovers(t *testing.T) {
+	f := &flakyClient{MockClient: *NewMock(), failures: 2, err: context.DeadlineExceeded}
+	now := time.Unix(0, 0)
+	b := NewCircuitBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+	r := NewResilient(f, RetryPolicy{MaxAttempts: 1}, b)
+
+	for i := 0; i < 2; i++ {
+		if _, _, _, _, _, err := r.ConfirmationsFromTxHash(context.Background(), "0xabc"); !IsTransient(err) {
+			t.Fatalf("call %d: expected transient error, got %v", i, err)
+		}
+	}
+	if _, _, _, _, _, err := r.ConfirmationsFromTxHash(context.Background(), "0xabc"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected open circuit, got %v", err)
+	}
+	if f.calls != 2 {
+		t.Fatalf("open breaker must not call the node, got %d calls", f.calls)
+	}
+
+	now = now.Add(time.Minute)
+	if _, _, _, found, _, err := r.ConfirmationsFromTxHash(context.Background(), "0xabc"); err != nil || found {
+		t.Fatalf("expected trial call to succeed with not-found, found=%v err=%v", found, err)
+	}
+}
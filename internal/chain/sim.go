@@ -0,0 +1,363 @@
+package chain
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// SimTx is a transaction submitted to a Simulator. Its transfer logs and internal
+// transfers take the transaction's hash, and the logs their block and log index, when it
+// is mined.
+type SimTx struct {
+	Tx
+	Logs     []TransferLog
+	Internal []InternalTransfer
+}
+
+type simBlock struct {
+	Header
+	txs []SimTx
+}
+
+// Simulator is a scriptable in-memory chain for tests. Unlike MockClient it keeps a block
+// tree: transactions wait in a mempool until mined, Reorg replaces the tip with a fork,
+// and every lookup answers from the canonical chain only. It implements ChainClient and
+// all the optional capabilities, so engine tests can play out whole timelines such as
+// "included at 90, reorged out at 95, re-included at 97".
+type Simulator struct {
+	mu        sync.Mutex
+	blocks    map[string]*simBlock
+	canonical []string // block hash by number
+	mempool   []SimTx
+	dropped   map[string]SimTx
+	reverted  map[string]bool
+	tags      map[string]uint64
+	subs      map[chan Header]struct{}
+	nextHash  uint64
+}
+
+// NewSimulator returns a chain holding only the genesis block, number 0.
+func NewSimulator() *Simulator {
+	s := &Simulator{
+		blocks:   make(map[string]*simBlock),
+		dropped:  make(map[string]SimTx),
+		reverted: make(map[string]bool),
+		tags:     make(map[string]uint64),
+		subs:     make(map[chan Header]struct{}),
+	}
+	s.appendBlock(nil)
+	return s
+}
+
+// appendBlock adds a block with txs on top of the canonical head. Callers hold mu.
+func (s *Simulator) appendBlock(txs []SimTx) *simBlock {
+	s.nextHash++
+	b := &simBlock{Header: Header{Number: uint64(len(s.canonical)), Hash: fmt.Sprintf("0x%064x", s.nextHash)}}
+	if len(s.canonical) > 0 {
+		b.ParentHash = s.canonical[len(s.canonical)-1]
+	}
+	var logIndex uint
+	for _, tx := range txs {
+		mined := SimTx{Tx: tx.Tx}
+		for _, l := range tx.Logs {
+			l.TxHash, l.BlockNumber, l.BlockHash, l.LogIndex = tx.Hash, b.Number, b.Hash, logIndex
+			l.Token, l.From, l.To = strings.ToLower(l.Token), strings.ToLower(l.From), strings.ToLower(l.To)
+			logIndex++
+			mined.Logs = append(mined.Logs, l)
+		}
+		for _, t := range tx.Internal {
+			t.TxHash = tx.Hash
+			mined.Internal = append(mined.Internal, t)
+		}
+		b.txs = append(b.txs, mined)
+	}
+	s.blocks[b.Hash] = b
+	s.canonical = append(s.canonical, b.Hash)
+	for ch := range s.subs {
+		select {
+		case ch <- b.Header:
+		default:
+			// a slow subscriber misses heads, as with a real node
+		}
+	}
+	return b
+}
+
+// Send puts tx in the mempool; the next mined block includes it.
+func (s *Simulator) Send(tx SimTx) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mempool = append(s.mempool, tx)
+}
+
+// Mine mines n blocks, the first of which includes the whole mempool, and returns the
+// new head.
+func (s *Simulator) Mine(n int) Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.appendBlock(s.mempool)
+		s.mempool = nil
+	}
+	return s.head()
+}
+
+// MineTo mines empty blocks (apart from the mempool) until the head is number.
+func (s *Simulator) MineTo(number uint64) Header {
+	s.mu.Lock()
+	n := int(number) - (len(s.canonical) - 1)
+	s.mu.Unlock()
+	return s.Mine(n)
+}
+
+// Reorg replaces the last depth canonical blocks with a fork of depth+1 empty blocks, so
+// the head moves one block higher. Transactions from the orphaned blocks go back to the
+// mempool, as a node would re-queue them; Drop keeps one out. It returns the new head.
+func (s *Simulator) Reorg(depth int) Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if depth >= len(s.canonical) {
+		depth = len(s.canonical) - 1 // genesis stays
+	}
+	fork := len(s.canonical) - depth
+	var orphaned []SimTx
+	for _, h := range s.canonical[fork:] {
+		for _, tx := range s.blocks[h].txs {
+			orphaned = append(orphaned, SimTx{Tx: tx.Tx, Logs: tx.Logs, Internal: tx.Internal})
+		}
+	}
+	s.canonical = s.canonical[:fork]
+	s.mempool = append(orphaned, s.mempool...)
+	for i := 0; i <= depth; i++ {
+		s.appendBlock(nil)
+	}
+	return s.head()
+}
+
+// Drop removes a transaction from the mempool, as if it had been evicted. It reports
+// whether the transaction was pending.
+func (s *Simulator) Drop(txHash string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, tx := range s.mempool {
+		if tx.Hash == txHash {
+			s.dropped[txHash] = tx
+			s.mempool = append(s.mempool[:i:i], s.mempool[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Reinclude puts a dropped transaction back in the mempool. It reports whether the
+// transaction had been dropped.
+func (s *Simulator) Reinclude(txHash string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx, ok := s.dropped[txHash]
+	if ok {
+		delete(s.dropped, txHash)
+		s.mempool = append(s.mempool, tx)
+	}
+	return ok
+}
+
+// SetReverted flips the receipt status of a transaction. A reverted transaction's
+// transfer logs and internal transfers are no longer reported.
+func (s *Simulator) SetReverted(txHash string, reverted bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reverted[txHash] = reverted
+}
+
+// SetTag points a block tag ("safe", "finalized") at a canonical block number.
+func (s *Simulator) SetTag(tag string, number uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tags[tag] = number
+}
+
+// Head returns the canonical head.
+func (s *Simulator) Head() Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.head()
+}
+
+func (s *Simulator) head() Header {
+	return s.blocks[s.canonical[len(s.canonical)-1]].Header
+}
+
+// canonicalBlock returns the canonical block at number. Callers hold mu.
+func (s *Simulator) canonicalBlock(number uint64) (*simBlock, error) {
+	if number >= uint64(len(s.canonical)) {
+		return nil, fmt.Errorf("block %d: %w", number, ErrNotFound)
+	}
+	return s.blocks[s.canonical[number]], nil
+}
+
+// findTx locates a transaction on the canonical chain. Callers hold mu.
+func (s *Simulator) findTx(txHash string) (*simBlock, bool) {
+	for _, h := range s.canonical {
+		b := s.blocks[h]
+		for _, tx := range b.txs {
+			if tx.Hash == txHash {
+				return b, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (s *Simulator) BlockNumber(ctx context.Context) (uint64, error) {
+	return s.Head().Number, nil
+}
+
+func (s *Simulator) Confirmations(ctx context.Context, txBlockNumber uint64) (uint64, error) {
+	head := s.Head().Number
+	if head < txBlockNumber {
+		return 0, nil
+	}
+	return head - txBlockNumber + 1, nil
+}
+
+func (s *Simulator) ConfirmationsFromTxHash(ctx context.Context, txHash string) (uint64, uint64, string, bool, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.findTx(txHash)
+	if !ok {
+		return 0, 0, "", false, false, nil
+	}
+	return b.Number, s.head().Number - b.Number + 1, b.Hash, true, s.reverted[txHash], nil
+}
+
+func (s *Simulator) Receipts(ctx context.Context, txHashes []string) (map[string]*Receipt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make(map[string]*Receipt, len(txHashes))
+	for _, h := range txHashes {
+		b, ok := s.findTx(h)
+		if !ok {
+			res[h] = nil
+			continue
+		}
+		res[h] = &Receipt{TxHash: h, BlockNumber: b.Number, BlockHash: b.Hash, Reverted: s.reverted[h]}
+	}
+	return res, nil
+}
+
+func (s *Simulator) HeaderByNumber(ctx context.Context, number uint64) (*Header, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.canonicalBlock(number)
+	if err != nil {
+		return nil, err
+	}
+	h := b.Header
+	return &h, nil
+}
+
+func (s *Simulator) BlockByNumber(ctx context.Context, number uint64) (*Block, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.canonicalBlock(number)
+	if err != nil {
+		return nil, err
+	}
+	res := &Block{Header: b.Header}
+	for _, tx := range b.txs {
+		t := tx.Tx
+		if t.Value != nil {
+			t.Value = new(big.Int).Set(t.Value)
+		}
+		res.Txs = append(res.Txs, t)
+	}
+	return res, nil
+}
+
+func (s *Simulator) TaggedHeader(ctx context.Context, tag string) (*Header, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.tags[tag]
+	if !ok {
+		return nil, fmt.Errorf("tag %s: %w", tag, ErrNotFound)
+	}
+	b, err := s.canonicalBlock(n)
+	if err != nil {
+		return nil, err
+	}
+	h := b.Header
+	return &h, nil
+}
+
+func (s *Simulator) TransferLogs(ctx context.Context, from, to uint64, tokens []string) ([]TransferLog, error) {
+	allowed := make(map[string]bool, len(tokens))
+	for _, t := range tokens {
+		allowed[strings.ToLower(t)] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []TransferLog
+	for n := from; n <= to && n < uint64(len(s.canonical)); n++ {
+		for _, tx := range s.blocks[s.canonical[n]].txs {
+			if s.reverted[tx.Hash] {
+				continue
+			}
+			for _, l := range tx.Logs {
+				if allowed[l.Token] {
+					res = append(res, l)
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+func (s *Simulator) InternalTransfers(ctx context.Context, number uint64, hash string) ([]InternalTransfer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.blocks[hash]
+	if !ok || b.Number != number {
+		return nil, fmt.Errorf("block %d %s: %w", number, hash, ErrNotFound)
+	}
+	var res []InternalTransfer
+	for _, tx := range b.txs {
+		if !s.reverted[tx.Hash] {
+			res = append(res, tx.Internal...)
+		}
+	}
+	return res, nil
+}
+
+// SubscribeNewHeads delivers every block the simulator appends, forks included. Heads
+// are buffered; a subscriber that falls far behind misses some.
+func (s *Simulator) SubscribeNewHeads(ctx context.Context, ch chan<- Header) (Subscription, error) {
+	feed := make(chan Header, 64)
+	s.mu.Lock()
+	s.subs[feed] = struct{}{}
+	s.mu.Unlock()
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer func() {
+			s.mu.Lock()
+			delete(s.subs, feed)
+			s.mu.Unlock()
+		}()
+		for {
+			select {
+			case h := <-feed:
+				select {
+				case ch <- h:
+				case <-quit:
+					return nil
+				}
+			case <-quit:
+				return nil
+			}
+		}
+	}), nil
+}
@@ -0,0 +1,101 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestSimulator_ReorgDropAndReinclude(t *testing.T) {
+	s := NewSimulator()
+	ctx := context.Background()
+	s.MineTo(89)
+	s.Send(SimTx{
+		Tx:   Tx{Hash: "0xdep", To: "0xa1", Value: big.NewInt(5)},
+		Logs: []TransferLog{{Token: "0xUSDC", To: "0xA1", Value: big.NewInt(7)}},
+	})
+	if h := s.Mine(1); h.Number != 90 {
+		t.Fatalf("expected head 90, got %d", h.Number)
+	}
+	old90, _ := s.HeaderByNumber(ctx, 90)
+	s.MineTo(95)
+
+	txBlock, conf, hash, found, _, _ := s.ConfirmationsFromTxHash(ctx, "0xdep")
+	if !found || txBlock != 90 || conf != 6 || hash != old90.Hash {
+		t.Fatalf("before reorg: block %d, %d confirmations, hash %s, found %v", txBlock, conf, hash, found)
+	}
+	logs, _ := s.TransferLogs(ctx, 0, 95, []string{"0xusdc"})
+	if len(logs) != 1 || logs[0].BlockNumber != 90 || logs[0].To != "0xa1" || logs[0].TxHash != "0xdep" {
+		t.Fatalf("unexpected logs %+v", logs)
+	}
+
+	// the fork orphans 90-95 and the tx is evicted instead of re-mined
+	if h := s.Reorg(6); h.Number != 96 {
+		t.Fatalf("expected head 96 after the reorg, got %d", h.Number)
+	}
+	if !s.Drop("0xdep") {
+		t.Fatalf("expected the orphaned tx back in the mempool")
+	}
+	new90, _ := s.HeaderByNumber(ctx, 90)
+	if new90.Hash == old90.Hash || new90.ParentHash != old90.ParentHash {
+		t.Fatalf("expected block 90 replaced on the same parent, got %+v (was %+v)", new90, old90)
+	}
+	if _, _, _, found, _, _ := s.ConfirmationsFromTxHash(ctx, "0xdep"); found {
+		t.Fatalf("expected the tx to be gone after the reorg")
+	}
+	if recs, _ := s.Receipts(ctx, []string{"0xdep"}); recs["0xdep"] != nil {
+		t.Fatalf("expected no receipt, got %+v", recs["0xdep"])
+	}
+
+	s.Reinclude("0xdep")
+	s.Mine(1)
+	txBlock, _, _, found, reverted, _ := s.ConfirmationsFromTxHash(ctx, "0xdep")
+	if !found || txBlock != 97 || reverted {
+		t.Fatalf("expected the tx re-included at 97, got block %d found %v reverted %v", txBlock, found, reverted)
+	}
+
+	s.SetReverted("0xdep", true)
+	recs, _ := s.Receipts(ctx, []string{"0xdep"})
+	if r := recs["0xdep"]; r == nil || !r.Reverted || r.BlockNumber != 97 {
+		t.Fatalf("expected a reverted receipt at 97, got %+v", r)
+	}
+	if logs, _ := s.TransferLogs(ctx, 0, 97, []string{"0xusdc"}); len(logs) != 0 {
+		t.Fatalf("a reverted tx emits no logs, got %+v", logs)
+	}
+}
+
+func TestSimulator_TagsAndHeads(t *testing.T) {
+	s := NewSimulator()
+	ctx := context.Background()
+	if _, err := s.TaggedHeader(ctx, TagFinalized); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an unset tag, got %v", err)
+	}
+
+	heads := make(chan Header, 8)
+	sub, err := s.SubscribeNewHeads(ctx, heads)
+	if err != nil {
+		t.Fatalf("SubscribeNewHeads: %v", err)
+	}
+	defer sub.Unsubscribe()
+	s.Mine(3)
+	s.SetTag(TagFinalized, 1)
+	for want := uint64(1); want <= 3; want++ {
+		select {
+		case h := <-heads:
+			if h.Number != want {
+				t.Fatalf("expected head %d, got %d", want, h.Number)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for head %d", want)
+		}
+	}
+	h, err := s.TaggedHeader(ctx, TagFinalized)
+	if err != nil || h.Number != 1 {
+		t.Fatalf("TaggedHeader: %+v %v", h, err)
+	}
+	if _, err := s.BlockByNumber(ctx, 4); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound above the head, got %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// InternalTransfer is a value transfer made by a contract inside a transaction, found in
+// its call trace. Index is the frame's position in a depth-first walk of the transaction's
+// call tree, where 0 is the top-level call; internal transfers therefore have Index >= 1.
+type InternalTransfer struct {
+	TxHash string
+	Index  int64
+	From   string
+	To     string
+	Value  *big.Int
+}
+
+// TraceSource is implemented by clients that can trace a block's internal calls. Clients
+// whose node serves no tracing API return ErrUnsupported. Transfers inside reverted frames
+// are left out.
+type TraceSource interface {
+	InternalTransfers(ctx context.Context, number uint64, hash string) ([]InternalTransfer, error)
+}
+
+// tracing APIs, probed in this order
+const (
+	traceUnknown int32 = iota
+	traceDebug         // debug_traceBlockByHash with callTracer (geth, reth)
+	traceParity        // trace_block (Erigon, Nethermind)
+	traceNone
+)
+
+// InternalTransfers traces block number (which must have hash) with whichever tracing API
+// the node serves, remembering the first one that works.
+func (c *Client) InternalTransfers(ctx context.Context, number uint64, hash string) ([]InternalTransfer, error) {
+	if c.rpc == nil {
+		return nil, ErrUnsupported
+	}
+	for {
+		switch c.traceAPI.Load() {
+		case traceDebug:
+			return c.debugTransfers(ctx, hash)
+		case traceParity:
+			return c.parityTransfers(ctx, number, hash)
+		case traceNone:
+			return nil, ErrUnsupported
+		}
+		// probe: a node without the method answers "method not found"
+		res, err := c.debugTransfers(ctx, hash)
+		if !isMethodNotFound(err) {
+			if err == nil {
+				c.traceAPI.Store(traceDebug)
+			}
+			return res, err
+		}
+		res, err = c.parityTransfers(ctx, number, hash)
+		if !isMethodNotFound(err) {
+			if err == nil {
+				c.traceAPI.Store(traceParity)
+			}
+			return res, err
+		}
+		c.traceAPI.Store(traceNone)
+	}
+}
+
+func isMethodNotFound(err error) bool {
+	var rpcErr rpc.Error
+	return errors.As(err, &rpcErr) && rpcErr.ErrorCode() == -32601
+}
+
+type callFrame struct {
+	Type  string       `json:"type"`
+	From  string       `json:"from"`
+	To    string       `json:"to"`
+	Value *hexutil.Big `json:"value"`
+	Error string       `json:"error"`
+	Calls []callFrame  `json:"calls"`
+}
+
+type txTrace struct {
+	TxHash string    `json:"txHash"`
+	Result callFrame `json:"result"`
+}
+
+func (c *Client) debugTransfers(ctx context.Context, hash string) ([]InternalTransfer, error) {
+	var traces []txTrace
+	err := c.rpc.CallContext(ctx, &traces, "debug_traceBlockByHash", hash, map[string]string{"tracer": "callTracer"})
+	if err != nil {
+		return nil, Classify(err)
+	}
+	var res []InternalTransfer
+	for _, t := range traces {
+		if t.TxHash == "" {
+			return nil, &PermanentError{Err: errors.New("debug_traceBlockByHash: node does not report txHash")}
+		}
+		var index int64
+		var walk func(f callFrame, depth int)
+		walk = func(f callFrame, depth int) {
+			i := index
+			index++
+			if f.Error != "" {
+				// a reverted frame undoes its own transfer and every transfer below it,
+				// but the frames still count for numbering
+				skipFrames(f.Calls, &index)
+				return
+			}
+			if depth > 0 && isValueCall(f.Type) && f.Value != nil && f.Value.ToInt().Sign() > 0 {
+				res = append(res, InternalTransfer{
+					TxHash: t.TxHash,
+					Index:  i,
+					From:   strings.ToLower(f.From),
+					To:     strings.ToLower(f.To),
+					Value:  new(big.Int).Set(f.Value.ToInt()),
+				})
+			}
+			for _, sub := range f.Calls {
+				walk(sub, depth+1)
+			}
+		}
+		walk(t.Result, 0)
+	}
+	return res, nil
+}
+
+// skipFrames advances index past frames and all their descendants.
+func skipFrames(frames []callFrame, index *int64) {
+	for _, f := range frames {
+		*index++
+		skipFrames(f.Calls, index)
+	}
+}
+
+// isValueCall reports whether a callTracer frame type moves value to its "to" address.
+// DELEGATECALL and STATICCALL cannot, CALLCODE runs "to"'s code in the caller's context so
+// its value never leaves the caller, and CREATE targets a fresh contract.
+func isValueCall(typ string) bool {
+	switch strings.ToUpper(typ) {
+	case "CALL", "SELFDESTRUCT":
+		return true
+	}
+	return false
+}
+
+type parityTrace struct {
+	Action struct {
+		CallType      string       `json:"callType"`
+		From          string       `json:"from"`
+		To            string       `json:"to"`
+		Value         *hexutil.Big `json:"value"`
+		Address       string       `json:"address"`
+		RefundAddress string       `json:"refundAddress"`
+		Balance       *hexutil.Big `json:"balance"`
+	} `json:"action"`
+	BlockHash       string `json:"blockHash"`
+	TransactionHash string `json:"transactionHash"`
+	TraceAddress    []int  `json:"traceAddress"`
+	Type            string `json:"type"`
+	Error           string `json:"error"`
+}
+
+func (c *Client) parityTransfers(ctx context.Context, number uint64, hash string) ([]InternalTransfer, error) {
+	var traces []parityTrace
+	if err := c.rpc.CallContext(ctx, &traces, "trace_block", hexutil.EncodeUint64(number)); err != nil {
+		return nil, Classify(err)
+	}
+	var res []InternalTransfer
+	var tx string
+	var index int64
+	var reverted [][]int // trace addresses of reverted frames in the current tx
+	for _, t := range traces {
+		if t.TransactionHash == "" {
+			continue // block and uncle rewards
+		}
+		if !strings.EqualFold(t.BlockHash, hash) {
+			// trace_block takes a number; the block changed under us
+			return nil, &TransientError{Err: fmt.Errorf("trace_block %d: got block %s, want %s", number, t.BlockHash, hash)}
+		}
+		if t.TransactionHash != tx {
+			tx, index, reverted = t.TransactionHash, 0, nil
+		}
+		i := index
+		index++
+		if t.Error != "" {
+			reverted = append(reverted, t.TraceAddress)
+			continue
+		}
+		if len(t.TraceAddress) == 0 || underAny(t.TraceAddress, reverted) {
+			continue
+		}
+		var to string
+		var value *hexutil.Big
+		switch {
+		case t.Type == "call" && t.Action.CallType == "call":
+			to, value = t.Action.To, t.Action.Value
+		case t.Type == "suicide":
+			to, value = t.Action.RefundAddress, t.Action.Balance
+		default:
+			continue
+		}
+		if value == nil || value.ToInt().Sign() <= 0 {
+			continue
+		}
+		from := t.Action.From
+		if t.Type == "suicide" {
+			from = t.Action.Address
+		}
+		res = append(res, InternalTransfer{
+			TxHash: t.TransactionHash,
+			Index:  i,
+			From:   strings.ToLower(from),
+			To:     strings.ToLower(to),
+			Value:  new(big.Int).Set(value.ToInt()),
+		})
+	}
+	return res, nil
+}
+
+// underAny reports whether trace address a is inside one of the frames in prefixes.
+func underAny(a []int, prefixes [][]int) bool {
+	for _, p := range prefixes {
+		if len(p) >= len(a) {
+			continue
+		}
+		match := true
+		for i := range p {
+			if p[i] != a[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
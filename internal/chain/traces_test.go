@@ -0,0 +1,115 @@
+package chain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+const traceBlockHash = "0x00000000000000000000000000000000000000000000000000000000000000bb"
+
+func TestClient_InternalTransfersFromCallTracer(t *testing.T) {
+	calls := map[string]int{}
+	c, _ := newRPCServer(t, func(method string, params json.RawMessage) interface{} {
+		calls[method]++
+		if method != "debug_traceBlockByHash" {
+			return nil
+		}
+		return []interface{}{map[string]interface{}{
+			"txHash": "0xt1",
+			"result": map[string]interface{}{
+				"type": "CALL", "from": "0xuser", "to": "0xwallet", "value": "0x64",
+				"calls": []interface{}{
+					// frame 1: the wallet pays us
+					map[string]interface{}{"type": "CALL", "from": "0xWallet", "to": "0xDeposit", "value": "0x5"},
+					// frame 2: reverted, so neither it nor frame 3 below it moved value
+					map[string]interface{}{"type": "CALL", "from": "0xwallet", "to": "0xdeposit", "value": "0x6", "error": "execution reverted",
+						"calls": []interface{}{map[string]interface{}{"type": "CALL", "from": "0xx", "to": "0xdeposit", "value": "0x7"}}},
+					// frame 4: delegatecall value is not a transfer
+					map[string]interface{}{"type": "DELEGATECALL", "from": "0xwallet", "to": "0xlib", "value": "0x8"},
+					// frame 5
+					map[string]interface{}{"type": "CALL", "from": "0xwallet", "to": "0xdeposit", "value": "0x9"},
+					// frame 6: callcode runs the target's code in the wallet; nothing reaches it
+					map[string]interface{}{"type": "CALLCODE", "from": "0xwallet", "to": "0xdeposit", "value": "0xa"},
+				},
+			},
+		}}
+	})
+
+	res, err := c.InternalTransfers(context.Background(), 90, traceBlockHash)
+	if err != nil {
+		t.Fatalf("InternalTransfers: %v", err)
+	}
+	if len(res) != 2 {
+		t.Fatalf("expected 2 internal transfers, got %+v", res)
+	}
+	if res[0].Index != 1 || res[0].To != "0xdeposit" || res[0].From != "0xwallet" || res[0].Value.Int64() != 5 {
+		t.Fatalf("unexpected first transfer %+v", res[0])
+	}
+	if res[1].Index != 5 || res[1].Value.Int64() != 9 {
+		t.Fatalf("unexpected second transfer %+v", res[1])
+	}
+	if calls["trace_block"] != 0 {
+		t.Fatalf("trace_block should not be probed when the debug API works")
+	}
+}
+
+func TestClient_InternalTransfersFallsBackToTraceBlock(t *testing.T) {
+	notFound := &rpcErrorObject{Code: -32601, Message: "the method does not exist/is not available"}
+	debugCalls := 0
+	c, _ := newRPCServer(t, func(method string, params json.RawMessage) interface{} {
+		switch method {
+		case "debug_traceBlockByHash":
+			debugCalls++
+			return notFound
+		case "trace_block":
+			trace := func(addr []int, typ string, action map[string]interface{}, errMsg string) map[string]interface{} {
+				tr := map[string]interface{}{"action": action, "blockHash": traceBlockHash, "transactionHash": "0xt1", "traceAddress": addr, "type": typ}
+				if errMsg != "" {
+					tr["error"] = errMsg
+				}
+				return tr
+			}
+			return []interface{}{
+				trace([]int{}, "call", map[string]interface{}{"callType": "call", "from": "0xuser", "to": "0xwallet", "value": "0x64"}, ""),
+				trace([]int{0}, "call", map[string]interface{}{"callType": "call", "from": "0xwallet", "to": "0xdeposit", "value": "0x5"}, "Reverted"),
+				trace([]int{0, 0}, "call", map[string]interface{}{"callType": "call", "from": "0xx", "to": "0xdeposit", "value": "0x7"}, ""),
+				trace([]int{1}, "suicide", map[string]interface{}{"address": "0xwallet", "refundAddress": "0xdeposit", "balance": "0x9"}, ""),
+				// callcode keeps the value in the wallet
+				trace([]int{2}, "call", map[string]interface{}{"callType": "callcode", "from": "0xwallet", "to": "0xdeposit", "value": "0xb"}, ""),
+				// block reward: no transaction
+				map[string]interface{}{"action": map[string]interface{}{"author": "0xminer", "value": "0x1"}, "blockHash": traceBlockHash, "traceAddress": []int{}, "type": "reward"},
+			}
+		}
+		return nil
+	})
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		res, err := c.InternalTransfers(ctx, 90, traceBlockHash)
+		if err != nil {
+			t.Fatalf("InternalTransfers: %v", err)
+		}
+		if len(res) != 1 || res[0].Index != 3 || res[0].From != "0xwallet" || res[0].Value.Int64() != 9 {
+			t.Fatalf("expected only the selfdestruct transfer, got %+v", res)
+		}
+	}
+	if debugCalls != 1 {
+		t.Fatalf("expected the debug API to be probed once, got %d calls", debugCalls)
+	}
+
+	// the block at that height changed between the scan and the trace
+	if _, err := c.InternalTransfers(ctx, 90, "0xother"); !IsTransient(err) {
+		t.Fatalf("expected transient error for a replaced block, got %v", err)
+	}
+}
+
+func TestClient_InternalTransfersUnsupported(t *testing.T) {
+	c, _ := newRPCServer(t, func(method string, params json.RawMessage) interface{} {
+		return &rpcErrorObject{Code: -32601, Message: "method not found"}
+	})
+	if _, err := c.InternalTransfers(context.Background(), 90, traceBlockHash); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+}
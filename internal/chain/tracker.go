@@ -0,0 +1,167 @@
+package chain
+
+import "context"
+
+// Reorg describes a contiguous range of blocks that left the canonical chain.
+type Reorg struct {
+	From     uint64
+	To       uint64
+	Depth    uint64
+	Orphaned []Header
+}
+
+// HeaderTracker keeps the last N canonical headers and detects reorgs from parent-hash
+// breaks. It is not safe for concurrent use.
+type HeaderTracker struct {
+	size    int
+	headers []Header
+}
+
+// NewHeaderTracker creates a tracker that remembers up to size headers.
+func NewHeaderTracker(size int) *HeaderTracker {
+	if size < 1 {
+		size = 1
+	}
+	return &HeaderTracker{size: size}
+}
+
+// Headers returns a copy of the tracked headers, oldest first.
+func (t *HeaderTracker) Headers() []Header {
+	return append([]Header(nil), t.headers...)
+}
+
+// Update extends the tracked chain up to head. If a tracked header is no longer canonical
+// it walks back to the common ancestor and returns the orphaned range. When the reorg is
+// deeper than the tracker's window, every tracked header is reported as orphaned.
+//
+// A head below the tracked tip usually comes from a lagging or failed-over provider, so it
+// says nothing about the blocks above it: only the tracked headers at or below head are
+// re-verified (see regress).
+func (t *HeaderTracker) Update(ctx context.Context, src HeaderSource, head uint64) (*Reorg, error) {
+	var reorg *Reorg
+	for {
+		if len(t.headers) == 0 {
+			h, err := src.HeaderByNumber(ctx, head)
+			if err != nil {
+				return reorg, err
+			}
+			t.push(*h)
+			return reorg, nil
+		}
+		tip := t.headers[len(t.headers)-1]
+		if tip.Number > head {
+			r, err := t.regress(ctx, src, head)
+			return mergeReorgs(reorg, r), err
+		}
+		if tip.Number == head {
+			h, err := src.HeaderByNumber(ctx, head)
+			if err != nil {
+				return reorg, err
+			}
+			if h.Hash == tip.Hash {
+				return reorg, nil
+			}
+		} else {
+			h, err := src.HeaderByNumber(ctx, tip.Number+1)
+			if err != nil {
+				return reorg, err
+			}
+			if h.ParentHash == tip.Hash {
+				t.push(*h)
+				continue
+			}
+		}
+		r, err := t.rollback(ctx, src)
+		if err != nil {
+			return reorg, err
+		}
+		reorg = mergeReorgs(reorg, r)
+	}
+}
+
+// rollback drops tracked headers until one matches the canonical chain and returns the
+// dropped ones as a Reorg.
+func (t *HeaderTracker) rollback(ctx context.Context, src HeaderSource) (*Reorg, error) {
+	i := len(t.headers) - 1
+	for ; i >= 0; i-- {
+		hdr := t.headers[i]
+		h, err := src.HeaderByNumber(ctx, hdr.Number)
+		if err != nil {
+			return nil, err
+		}
+		if h.Hash == hdr.Hash {
+			break
+		}
+	}
+	if i == len(t.headers)-1 {
+		// the tip became canonical again between calls; nothing was orphaned
+		return nil, nil
+	}
+	orphaned := append([]Header(nil), t.headers[i+1:]...)
+	t.headers = t.headers[:i+1]
+	return &Reorg{
+		From:     orphaned[0].Number,
+		To:       orphaned[len(orphaned)-1].Number,
+		Depth:    uint64(len(orphaned)),
+		Orphaned: orphaned,
+	}, nil
+}
+
+// regress handles a head below the tracked tip. The tracked headers at or below head are
+// checked against src, newest first; those that are no longer canonical are returned as
+// a Reorg ending at head. The headers above head are never reported: they are kept while
+// head's header still matches, and dropped untracked once it does not, since they descend
+// from an orphaned block (deposits in them are caught by their own block hash check).
+func (t *HeaderTracker) regress(ctx context.Context, src HeaderSource, head uint64) (*Reorg, error) {
+	top := len(t.headers) - 1
+	for top >= 0 && t.headers[top].Number > head {
+		top--
+	}
+	i := top
+	for ; i >= 0; i-- {
+		hdr := t.headers[i]
+		h, err := src.HeaderByNumber(ctx, hdr.Number)
+		if err != nil {
+			return nil, err
+		}
+		if h.Hash == hdr.Hash {
+			break
+		}
+	}
+	if i == top {
+		return nil, nil
+	}
+	orphaned := append([]Header(nil), t.headers[i+1:top+1]...)
+	t.headers = t.headers[:i+1]
+	return &Reorg{
+		From:     orphaned[0].Number,
+		To:       orphaned[len(orphaned)-1].Number,
+		Depth:    uint64(len(orphaned)),
+		Orphaned: orphaned,
+	}, nil
+}
+
+func (t *HeaderTracker) push(h Header) {
+	t.headers = append(t.headers, h)
+	if len(t.headers) > t.size {
+		t.headers = t.headers[len(t.headers)-t.size:]
+	}
+}
+
+func mergeReorgs(a, b *Reorg) *Reorg {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if b.From < a.From {
+		a.From = b.From
+	}
+	if b.To > a.To {
+		a.To = b.To
+	}
+	a.Depth = a.To - a.From + 1
+	a.Orphaned = append(a.Orphaned, b.Orphaned...)
+	return a
+}
@@ -0,0 +1,88 @@
+package chain_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/namtran/creditengine/internal/chain"
+)
+
+// linkBlocks builds blocks from..to whose hashes are prefixed with fork, chained on parent.
+func linkBlocks(m *chain.MockClient, fork string, parent string, from, to uint64) {
+	for n := from; n <= to; n++ {
+		h := fmt.Sprintf("0x%s%d", fork, n)
+		m.Blocks[n] = &chain.Block{Header: chain.Header{Number: n, Hash: h, ParentHash: parent}}
+		parent = h
+	}
+}
+
+func TestHeaderTracker_DetectsReorgDepth(t *testing.T) {
+	ctx := context.Background()
+	m := chain.NewMock()
+	linkBlocks(m, "a", "0xa9", 10, 15)
+
+	tr := chain.NewHeaderTracker(32)
+	if r, err := tr.Update(ctx, m, 10); err != nil || r != nil {
+		t.Fatalf("initial update: reorg=%v err=%v", r, err)
+	}
+	if r, err := tr.Update(ctx, m, 15); err != nil || r != nil {
+		t.Fatalf("extend: reorg=%v err=%v", r, err)
+	}
+
+	// blocks 13..15 are replaced by a longer fork 13..16
+	linkBlocks(m, "b", "0xa12", 13, 16)
+	r, err := tr.Update(ctx, m, 16)
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if r == nil {
+		t.Fatalf("expected reorg")
+	}
+	if r.From != 13 || r.To != 15 || r.Depth != 3 {
+		t.Fatalf("unexpected reorg range %d-%d depth %d", r.From, r.To, r.Depth)
+	}
+	if r.Orphaned[0].Hash != "0xa13" {
+		t.Fatalf("unexpected orphaned header %+v", r.Orphaned[0])
+	}
+
+	hs := tr.Headers()
+	if tip := hs[len(hs)-1]; tip.Hash != "0xb16" {
+		t.Fatalf("expected tip on new fork, got %+v", tip)
+	}
+}
+
+func TestHeaderTracker_HeadRegressionIsNotAReorg(t *testing.T) {
+	ctx := context.Background()
+	m := chain.NewMock()
+	linkBlocks(m, "a", "0xa9", 10, 15)
+
+	tr := chain.NewHeaderTracker(32)
+	if _, err := tr.Update(ctx, m, 10); err != nil {
+		t.Fatalf("initial update: %v", err)
+	}
+	if _, err := tr.Update(ctx, m, 15); err != nil {
+		t.Fatalf("extend: %v", err)
+	}
+
+	// a lagging provider reports 12: 13..15 stay tracked and nothing is orphaned
+	if r, err := tr.Update(ctx, m, 12); err != nil || r != nil {
+		t.Fatalf("regressed head: reorg=%v err=%v", r, err)
+	}
+	if hs := tr.Headers(); len(hs) != 6 || hs[len(hs)-1].Hash != "0xa15" {
+		t.Fatalf("expected 10..15 still tracked, got %+v", hs)
+	}
+
+	// the lower head does disagree about 12: only blocks up to it are orphaned
+	linkBlocks(m, "c", "0xa11", 12, 12)
+	r, err := tr.Update(ctx, m, 12)
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if r == nil || r.From != 12 || r.To != 12 || r.Depth != 1 {
+		t.Fatalf("expected block 12 orphaned, got %+v", r)
+	}
+	if hs := tr.Headers(); hs[len(hs)-1].Hash != "0xa11" {
+		t.Fatalf("expected tracking to resume from 11, got %+v", hs)
+	}
+}
@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/namtran/creditengine/internal/chain"
+	"github.com/namtran/creditengine/internal/hd"
+	"github.com/namtran/creditengine/internal/models"
+)
+
+var (
+	// ErrUnknownChain is returned for a chain the engine does not process.
+	ErrUnknownChain = errors.New("engine: unknown chain")
+	// ErrNoXPub is returned when allocating an address on a chain without Config.XPub.
+	ErrNoXPub = errors.New("engine: no xpub configured for chain")
+)
+
+// AllocateAddress creates an account on chainID with the next deposit address derived
+// from the chain's xpub. Only public keys are involved. When CREATE2 forwarders are
+// configured the account is linked to its counterfactual forwarder address.
+func (s *Service) AllocateAddress(ctx context.Context, chainID uint64) (*models.Account, error) {
+	var cfg *Config
+	for _, p := range s.procs {
+		if p.cfg.ChainID == chainID {
+			cfg = p.cfg
+		}
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("%w %d", ErrUnknownChain, chainID)
+	}
+	if cfg.XPub == "" || cfg.Bitcoin {
+		return nil, fmt.Errorf("%w %d", ErrNoXPub, chainID)
+	}
+	xpub, err := hd.ParseXPub(cfg.XPub)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.AllocateAccount(ctx, chainID, func(index uint32) (string, string, error) {
+		addr, err := hd.DepositAddress(xpub, index)
+		if errors.Is(err, hd.ErrInvalidChild) {
+			return "", "", nil
+		}
+		if err != nil || !cfg.forwarders() {
+			return addr, "", err
+		}
+		fwd, err := chain.ForwarderAddress(cfg.ForwarderFactory, cfg.ForwarderInitCodeHash, addr)
+		return addr, fwd, err
+	})
+}
+
+// handleAllocate serves POST /accounts?chain_id=N, allocating the next deposit address.
+// chain_id defaults to Config.ChainID.
+func (s *Service) handleAllocate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	chainID := s.cfg.ChainID
+	if v := r.URL.Query().Get("chain_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid chain_id", http.StatusBadRequest)
+			return
+		}
+		chainID = id
+	}
+	acct, err := s.AllocateAddress(r.Context(), chainID)
+	switch {
+	case errors.Is(err, ErrUnknownChain):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrNoXPub):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to allocate address on chain %d: %v", chainID, err)
+		http.Error(w, "allocation failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		ChainID          uint64 `json:"chain_id"`
+		Address          string `json:"address"`
+		DerivationIndex  int64  `json:"derivation_index"`
+		ForwarderAddress string `json:"forwarder_address,omitempty"`
+	}{acct.ChainID, acct.Address, acct.DerivationIndex.Int64, acct.ForwarderAddress.String})
+}
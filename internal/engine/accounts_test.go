@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/namtran/creditengine/internal/chain"
+	"github.com/namtran/creditengine/internal/hd"
+	st "github.com/namtran/creditengine/internal/store"
+)
+
+// testXPub is the BIP32 test vector 1 key at m/0H.
+const testXPub = "xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw"
+
+func TestHandleAllocate_DerivesNextAddress(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	xpub, _ := hd.ParseXPub(testXPub)
+	want, _ := hd.DepositAddress(xpub, 7)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO hd_counters(chain_id, next_index) VALUES($1, 1) ON CONFLICT (chain_id) DO UPDATE SET next_index = hd_counters.next_index + 1 RETURNING next_index - 1")).
+		WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"index"}).AddRow(7))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO accounts(chain_id, address, balance, derivation_index, forwarder_address) VALUES($1, $2, 0, $3, $4) RETURNING id")).
+		WithArgs(1, want, 7, nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+	mock.ExpectCommit()
+
+	cfg := DefaultConfig()
+	cfg.XPub = testXPub
+	cfg.Chains = []ChainConfig{{ChainID: 137}}
+	reg := chain.NewRegistry()
+	_ = reg.Register(1, chain.NewMock())
+	_ = reg.Register(137, chain.NewMock())
+	svc := NewServiceWithRegistry(cfg, st.New(db), reg)
+	h := svc.adminHandler()
+
+	// the public listener does not expose allocation at all
+	rec := httptest.NewRecorder()
+	svc.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/accounts", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("public POST /accounts: expected 404, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/accounts", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var got struct {
+		ChainID         uint64 `json:"chain_id"`
+		Address         string `json:"address"`
+		DerivationIndex int64  `json:"derivation_index"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.ChainID != 1 || got.Address != want || got.DerivationIndex != 7 {
+		t.Fatalf("unexpected allocation %+v, want address %s", got, want)
+	}
+
+	// chain 137 has no xpub of its own; chain 10 is not processed at all
+	for path, code := range map[string]int{"/accounts?chain_id=137": http.StatusBadRequest, "/accounts?chain_id=10": http.StatusNotFound} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Code != code {
+			t.Fatalf("%s: expected %d, got %d", path, code, rec.Code)
+		}
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/accounts", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET: expected 405, got %d", rec.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestAllocateAddress_LinksForwarder(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	const factory = "0xdeadbeef00000000000000000000000000000000"
+	const codeHash = "0xbc36789e7a1e281436464229828f817d6612f7b477d66591ff96a9e064bcc98a"
+	xpub, _ := hd.ParseXPub(testXPub)
+	addr, _ := hd.DepositAddress(xpub, 0)
+	fwd, _ := chain.ForwarderAddress(factory, codeHash, addr)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO hd_counters")).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"index"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO accounts(chain_id, address, balance, derivation_index, forwarder_address)")).
+		WithArgs(1, addr, 0, fwd).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+	// a malformed init code hash fails before the account is written
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO hd_counters")).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"index"}).AddRow(1))
+	mock.ExpectRollback()
+
+	cfg := DefaultConfig()
+	cfg.XPub = testXPub
+	cfg.ForwarderFactory = factory
+	cfg.ForwarderInitCodeHash = codeHash
+	svc := NewServiceWithStore(cfg, st.New(db), chain.NewMock())
+
+	acct, err := svc.AllocateAddress(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("AllocateAddress: %v", err)
+	}
+	if acct.ForwarderAddress.String != fwd {
+		t.Fatalf("expected forwarder %s, got %+v", fwd, acct.ForwarderAddress)
+	}
+
+	cfg.ForwarderInitCodeHash = "0x1234"
+	if _, err := svc.AllocateAddress(context.Background(), 1); err == nil {
+		t.Fatalf("expected an error for a malformed init code hash")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Alert is an event an operator has to look at, such as a credit taken back after a
+// deep reorg.
+type Alert struct {
+	Kind      string      `json:"kind"`
+	ChainID   uint64      `json:"chain_id"`
+	DepositID int64       `json:"deposit_id,omitempty"`
+	TxHash    string      `json:"tx_hash,omitempty"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	Time      time.Time   `json:"time"`
+}
+
+// Alerter delivers alerts.
+type Alerter interface {
+	Alert(ctx context.Context, a Alert) error
+}
+
+// newAlerter logs alerts and, when Config.AlertWebhook is set, also POSTs them to it.
+func newAlerter(cfg *Config) Alerter {
+	if cfg.AlertWebhook == "" {
+		return logAlerter{}
+	}
+	return &webhookAlerter{url: cfg.AlertWebhook, hc: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type logAlerter struct{}
+
+func (logAlerter) Alert(ctx context.Context, a Alert) error {
+	log.Printf("ALERT %s chain %d: %s", a.Kind, a.ChainID, a.Message)
+	return nil
+}
+
+type webhookAlerter struct {
+	url string
+	hc  *http.Client
+}
+
+func (w *webhookAlerter) Alert(ctx context.Context, a Alert) error {
+	_ = logAlerter{}.Alert(ctx, a)
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("alert webhook: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigValidate(t *testing.T) {
+	cases := []struct {
+		name  string
+		edit  func(c *Config)
+		fails string
+	}{
+		{"default", func(c *Config) {}, ""},
+		{"L2 on confirmations", func(c *Config) { c.L2 = true }, "L2 chains need finality mode"},
+		{"L2 finalized", func(c *Config) { c.L2 = true; c.FinalityMode = FinalityFinalized }, ""},
+		{"L2 safe", func(c *Config) { c.L2 = true; c.FinalityMode = FinalitySafe }, `L2 chains need finality mode "finalized", not "safe"`},
+		{"op-node implies L2", func(c *Config) { c.RollupNodeURL = "http://op-node:9545" }, "L2 chains need finality mode"},
+		{"L2 tier on confirmations", func(c *Config) {
+			c.L2, c.FinalityMode = true, FinalityFinalized
+			c.ConfirmationPolicy = &ConfirmationPolicy{Tiers: []ConfirmationTier{{Asset: AssetNative, Finality: FinalityConfirmations}}}
+		}, "tier 0"},
+		{"L2 tier on safe", func(c *Config) {
+			c.L2, c.FinalityMode = true, FinalityFinalized
+			c.ConfirmationPolicy = &ConfirmationPolicy{Tiers: []ConfirmationTier{{Asset: AssetNative, Confirmations: 6}, {Finality: FinalitySafe}}}
+		}, "tier 1"},
+		{"misspelled mode", func(c *Config) { c.FinalityMode = "finalised" }, `unknown finality mode "finalised"`},
+		{"empty mode", func(c *Config) { c.FinalityMode = "" }, "unknown finality mode"},
+		{"misspelled mode in Chains", func(c *Config) { c.Chains = []ChainConfig{{ChainID: 137, FinalityMode: "Safe"}} }, "chain 137"},
+		{"misspelled tier mode", func(c *Config) {
+			c.ConfirmationPolicy = &ConfirmationPolicy{Tiers: []ConfirmationTier{{Finality: "final"}}}
+		}, "tier 0: unknown finality mode"},
+		{"L2 in Chains", func(c *Config) { c.Chains = []ChainConfig{{ChainID: 10, L2: true}} }, "chain 10"},
+		{"watch past ReorgWindow", func(c *Config) { c.WatchWindow = 120 }, "exceeds ReorgWindow 128"},
+		{"tier watched past ReorgWindow", func(c *Config) {
+			c.Chains = []ChainConfig{{ChainID: 137, ConfirmationPolicy: &ConfirmationPolicy{Tiers: []ConfirmationTier{{Asset: AssetNative, Confirmations: 100}}}}}
+		}, "chain 137: watch period of 164"},
+		{"watching disabled", func(c *Config) { c.Confirmations, c.WatchWindow = 200, 0 }, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tc.edit(cfg)
+			err := cfg.validate()
+			if tc.fails == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.fails != "" && (err == nil || !strings.Contains(err.Error(), tc.fails)) {
+				t.Fatalf("expected an error containing %q, got %v", tc.fails, err)
+			}
+		})
+	}
+}
+
+// The top-level policy's amount bands are in the main chain's units, so other chains only
+// get a policy of their own.
+func TestChainConfigs_DoNotInheritConfirmationPolicy(t *testing.T) {
+	own := &ConfirmationPolicy{Tiers: []ConfirmationTier{{Asset: AssetNative, Confirmations: 256}}}
+	cfg := DefaultConfig()
+	cfg.ConfirmationPolicy = &ConfirmationPolicy{Tiers: []ConfirmationTier{{Asset: AssetNative, Confirmations: 6}}}
+	cfg.Chains = []ChainConfig{{ChainID: 137}, {ChainID: 56, ConfirmationPolicy: own}}
+
+	cfgs := cfg.chainConfigs()
+	if cfgs[0].ConfirmationPolicy != cfg.ConfirmationPolicy {
+		t.Fatalf("expected chain 1 to keep the top-level policy")
+	}
+	if cfgs[1].ConfirmationPolicy != nil {
+		t.Fatalf("expected chain 137 without a policy, got %+v", cfgs[1].ConfirmationPolicy)
+	}
+	if cfgs[2].ConfirmationPolicy != own {
+		t.Fatalf("expected chain 56 to use its own policy")
+	}
+}
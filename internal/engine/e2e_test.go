@@ -0,0 +1,173 @@
+package engine
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/namtran/creditengine/internal/chain/simulated"
+	"github.com/namtran/creditengine/internal/models"
+	st "github.com/namtran/creditengine/internal/store"
+)
+
+// simChain is a simulated go-ethereum chain with one funded sender.
+type simChain struct {
+	*backends.SimulatedBackend
+	key   *ecdsa.PrivateKey
+	nonce uint64
+}
+
+func newSimChain(t *testing.T) *simChain {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	alloc := core.GenesisAlloc{crypto.PubkeyToAddress(key.PublicKey): {Balance: big.NewInt(1e18)}}
+	sim := backends.NewSimulatedBackend(alloc, 8_000_000)
+	t.Cleanup(func() { _ = sim.Close() })
+	return &simChain{SimulatedBackend: sim, key: key}
+}
+
+// sendETH signs and submits a plain value transfer; it is mined by the next Commit.
+func (c *simChain) sendETH(t *testing.T, to common.Address, wei int64) *types.Transaction {
+	t.Helper()
+	ctx := context.Background()
+	price, err := c.SuggestGasPrice(ctx)
+	if err != nil {
+		t.Fatalf("SuggestGasPrice: %v", err)
+	}
+	tx := types.NewTx(&types.LegacyTx{Nonce: c.nonce, To: &to, Value: big.NewInt(wei), Gas: 21000, GasPrice: price})
+	signed, err := types.SignTx(tx, types.LatestSignerForChainID(c.Blockchain().Config().ChainID), c.key)
+	if err != nil {
+		t.Fatalf("SignTx: %v", err)
+	}
+	if err := c.SendTransaction(ctx, signed); err != nil {
+		t.Fatalf("SendTransaction: %v", err)
+	}
+	c.nonce++
+	return signed
+}
+
+func (c *simChain) mine(n int) {
+	for i := 0; i < n; i++ {
+		c.Commit()
+	}
+}
+
+// TestE2E_CreditsETHDeposit sends real ETH on the simulated backend and follows it from
+// the scanner through ProcessOnce to the credit.
+func TestE2E_CreditsETHDeposit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	sim := newSimChain(t)
+	account := common.HexToAddress("0x00000000000000000000000000000000000000A1")
+	tx := sim.sendETH(t, account, 1000)
+	sim.mine(1)
+	b1, err := sim.BlockByNumber(context.Background(), big.NewInt(1))
+	if err != nil {
+		t.Fatalf("BlockByNumber: %v", err)
+	}
+	txHash, blockHash := tx.Hash().Hex(), b1.Hash().Hex()
+
+	cfg := DefaultConfig()
+	cfg.ScanStartBlock = 1
+	svc := NewServiceWithStore(cfg, st.New(db), simulated.NewClient(sim.SimulatedBackend))
+	ctx := context.Background()
+
+	// the scanner finds the transfer in block 1
+	expectCheckpoint(mock, nil)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT address FROM accounts WHERE chain_id = $1")).WillReturnRows(sqlmock.NewRows([]string{"address"}).AddRow(account.Hex()))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO deposits(")).
+		WithArgs(1, txHash, int64(-1), int64(0), account.Hex(), nil, "1000", int64(1), blockHash, false, nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectBlockCommitted(mock, 1, blockHash)
+	if err := svc.procs[0].scanner.ScanOnce(ctx); err != nil {
+		t.Fatalf("ScanOnce error: %v", err)
+	}
+
+	pending := func(conf int) *sqlmock.Rows {
+		return depositRows().AddRow(1, 1, txHash, -1, 0, account.Hex(), nil, 1000, conf, 1, blockHash, "pending", time.Now(), false, nil)
+	}
+	// one confirmation: recorded, not credited
+	mock.ExpectQuery(pendingQuery).WillReturnRows(pending(0))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET tx_block = $1, block_hash = $2 WHERE id = $3")).WithArgs(1, blockHash, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET confirmations = $1 WHERE id = $2")).WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := svc.ProcessOnce(ctx); err != nil {
+		t.Fatalf("ProcessOnce error: %v", err)
+	}
+
+	// twelve confirmations: credited once, with the receipt's block as evidence
+	sim.mine(11)
+	mock.ExpectQuery(pendingQuery).WillReturnRows(pending(1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET tx_block = $1, block_hash = $2 WHERE id = $3")).WithArgs(1, blockHash, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET confirmations = $1 WHERE id = $2")).WithArgs(12, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	expectTransition(mock, 1, models.StatusPending, models.StatusCredited)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE accounts SET balance = balance + $1 WHERE chain_id = $2 AND address = $3")).WithArgs("1000", 1, account.Hex()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET credited_at = $1 WHERE id = $2")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audits(deposit_id, action, details, created_at) VALUES($1, $2, $3, $4)")).
+		WithArgs(1, "credited", jsonContains{`"mode":"confirmations"`, `"block_hash":"` + blockHash + `"`}, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	if err := svc.ProcessOnce(ctx); err != nil {
+		t.Fatalf("ProcessOnce error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+// TestE2E_ForkDropsDeposit forks the simulated chain below the deposit's block; the
+// longer fork does not contain the transaction, so the deposit is marked reorged.
+func TestE2E_ForkDropsDeposit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	sim := newSimChain(t)
+	ctx := context.Background()
+	genesis, _ := sim.BlockByNumber(ctx, big.NewInt(0))
+	tx := sim.sendETH(t, common.HexToAddress("0xa1"), 1000)
+	sim.mine(1)
+	b1, _ := sim.BlockByNumber(ctx, big.NewInt(1))
+
+	if err := sim.Fork(ctx, genesis.Hash()); err != nil {
+		t.Fatalf("Fork: %v", err)
+	}
+	sim.mine(2)
+	if fork1, _ := sim.BlockByNumber(ctx, big.NewInt(1)); fork1.Hash() == b1.Hash() {
+		t.Fatalf("expected the fork to replace block 1")
+	}
+
+	mock.ExpectQuery(pendingQuery).WillReturnRows(depositRows().AddRow(1, 1, tx.Hash().Hex(), -1, 0, "0xa1", nil, 1000, 1, 1, b1.Hash().Hex(), "pending", time.Now(), false, nil))
+	mock.ExpectBegin()
+	expectTransition(mock, 1, models.StatusPending, models.StatusReorged)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET reorged_at = $1 WHERE id = $2")).WithArgs(sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audits(deposit_id, action, created_at) VALUES($1, $2, $3)")).WithArgs(1, "reorged", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	svc := NewServiceWithStore(DefaultConfig(), st.New(db), simulated.NewClient(sim.SimulatedBackend))
+	if err := svc.ProcessOnce(ctx); err != nil {
+		t.Fatalf("ProcessOnce error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
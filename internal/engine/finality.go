@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/namtran/creditengine/internal/chain"
+	"github.com/namtran/creditengine/internal/models"
+)
+
+// FinalityMode selects when a deposit counts as final.
+type FinalityMode string
+
+const (
+	// FinalityConfirmations credits once a deposit has Config.Confirmations confirmations.
+	FinalityConfirmations FinalityMode = "confirmations"
+	// FinalitySafe credits once the deposit's block is at or below the "safe" head.
+	FinalitySafe FinalityMode = "safe"
+	// FinalityFinalized credits once the deposit's block is at or below the "finalized" head.
+	FinalityFinalized FinalityMode = "finalized"
+)
+
+// valid reports whether m is one of the known modes.
+func (m FinalityMode) valid() bool {
+	switch m {
+	case FinalityConfirmations, FinalitySafe, FinalityFinalized:
+		return true
+	}
+	return false
+}
+
+// tag returns the block tag the mode checks against, or "" for confirmation counting.
+func (m FinalityMode) tag() string {
+	switch m {
+	case FinalitySafe:
+		return chain.TagSafe
+	case FinalityFinalized:
+		return chain.TagFinalized
+	}
+	return ""
+}
+
+// taggedHead fetches the safe or finalized head for mode; it returns nil when the mode
+// counts confirmations instead.
+func (p *chainProcessor) taggedHead(ctx context.Context, mode FinalityMode) (*chain.Header, error) {
+	tag := mode.tag()
+	if tag == "" {
+		return nil, nil
+	}
+	src, ok := p.chain.(chain.TaggedHeadSource)
+	if !ok {
+		return nil, fmt.Errorf("finality mode %q: %w", mode, chain.ErrUnsupported)
+	}
+	return src.TaggedHeader(ctx, tag)
+}
+
+// taggedHeads fetches, once each, the safe and finalized heads that the pending deposits'
+// confirmation rules check against. Without a head nothing waiting on it can be credited
+// this cycle, but reorgs and confirmation counts are still recorded.
+func (p *chainProcessor) taggedHeads(ctx context.Context, deposits []models.Deposit) map[FinalityMode]*chain.Header {
+	res := make(map[FinalityMode]*chain.Header)
+	tried := make(map[FinalityMode]bool)
+	for _, d := range deposits {
+		mode := p.rule(d).mode
+		if d.Status != models.StatusPending || mode.tag() == "" || tried[mode] {
+			continue
+		}
+		tried[mode] = true
+		h, err := p.taggedHead(ctx, mode)
+		if err != nil {
+			log.Printf("failed to read %s head: %v", mode, err)
+			continue
+		}
+		res[mode] = h
+	}
+	return res
+}
+
+// finality decides whether a mined deposit is final under rule r and, if so, returns the
+// evidence to store with the credit. tagged holds the heads from taggedHeads.
+func (p *chainProcessor) finality(r confirmationRule, st txStatus, tagged map[FinalityMode]*chain.Header) (*models.FinalityEvidence, bool) {
+	ev := &models.FinalityEvidence{
+		Mode:          string(FinalityConfirmations),
+		TxBlock:       st.txBlock,
+		BlockHash:     st.blockHash,
+		Confirmations: st.confirmations,
+		Policy:        r.decision(p.cfg.ConfirmationPolicy),
+	}
+	if r.mode.tag() == "" {
+		return ev, st.confirmations >= r.confirmations
+	}
+	head := tagged[r.mode]
+	if head == nil || st.txBlock > head.Number {
+		return nil, false
+	}
+	ev.Mode = string(r.mode)
+	ev.TaggedBlock = head.Number
+	ev.TaggedHash = head.Hash
+	return ev, true
+}
@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/namtran/creditengine/internal/store"
+)
+
+// handleFlushes serves the forwarder sweeper on the admin listener:
+//
+//	GET /flushes?chain_id=N lists the chain's credited deposits still in forwarders
+//	POST /flushes?id=N      records that credited deposit N's forwarder has been flushed
+//
+// chain_id defaults to Config.ChainID.
+func (s *Service) handleFlushes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listFlushes(w, r)
+	case http.MethodPost:
+		s.markFlushed(w, r)
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Service) listFlushes(w http.ResponseWriter, r *http.Request) {
+	chainID := s.cfg.ChainID
+	if v := r.URL.Query().Get("chain_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid chain_id", http.StatusBadRequest)
+			return
+		}
+		chainID = id
+	}
+	deposits, err := s.store.DepositsNeedingFlush(r.Context(), chainID)
+	if err != nil {
+		log.Printf("failed to list deposits needing a flush on chain %d: %v", chainID, err)
+		http.Error(w, "listing failed", http.StatusInternalServerError)
+		return
+	}
+
+	type flush struct {
+		ID      int64  `json:"id"`
+		ChainID uint64 `json:"chain_id"`
+		TxHash  string `json:"tx_hash"`
+		Address string `json:"address"`
+		Token   string `json:"token,omitempty"`
+		Amount  string `json:"amount"`
+		Status  string `json:"status"`
+	}
+	res := make([]flush, 0, len(deposits))
+	for _, d := range deposits {
+		res = append(res, flush{d.ID, d.ChainID, d.TxHash, d.Address, d.Token.String, d.Amount.String(), string(d.Status)})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+func (s *Service) markFlushed(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	err = s.store.MarkFlushed(r.Context(), id)
+	if errors.Is(err, store.ErrNotFlushable) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to mark deposit %d flushed: %v", id, err)
+		http.Error(w, "update failed", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/namtran/creditengine/internal/chain"
+	st "github.com/namtran/creditengine/internal/store"
+)
+
+func TestHandleFlushes_ListsAndMarksForwarderDeposits(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM deposits WHERE chain_id = $1 AND needs_flush AND status = 'credited' ORDER BY id")).WithArgs(1).
+		WillReturnRows(depositRows().AddRow(9, 1, "0xtok", 4, 0, "0xowner", "0xusdc", "60", 12, 90, "0xb90", "credited", time.Now(), true, nil))
+	update := regexp.QuoteMeta("UPDATE deposits SET needs_flush = false WHERE id = $1 AND status = 'credited'")
+	mock.ExpectExec(update).WithArgs(9).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(update).WithArgs(10).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	svc := NewServiceWithStore(DefaultConfig(), st.New(db), chain.NewMock())
+	h := svc.adminHandler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/flushes", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var got []struct {
+		ID     int64  `json:"id"`
+		Token  string `json:"token"`
+		Amount string `json:"amount"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != 9 || got[0].Token != "0xusdc" || got[0].Amount != "60" {
+		t.Fatalf("unexpected flush list %+v", got)
+	}
+
+	for _, tc := range []struct {
+		path string
+		code int
+	}{
+		{"/flushes?id=9", http.StatusNoContent},
+		// deposit 10 is unknown or not credited yet
+		{"/flushes?id=10", http.StatusConflict},
+		{"/flushes?id=x", http.StatusBadRequest},
+	} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tc.path, nil))
+		if rec.Code != tc.code {
+			t.Fatalf("POST %s: expected %d, got %d", tc.path, tc.code, rec.Code)
+		}
+	}
+
+	// the sweeper API is admin-only
+	rec = httptest.NewRecorder()
+	svc.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/flushes", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("public GET /flushes: expected 404, got %d", rec.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
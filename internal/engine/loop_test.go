@@ -0,0 +1,111 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/namtran/creditengine/internal/chain"
+)
+
+func TestLoop_TicksPerHeadAndFallsBackToPolling(t *testing.T) {
+	mc := chain.NewMock()
+	cfg := DefaultConfig()
+	cfg.PollInterval = time.Hour
+	cfg.ResubscribeInterval = 10 * time.Millisecond
+	svc := NewServiceWithStore(cfg, nil, mc)
+
+	ticks := make(chan struct{}, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- svc.procs[0].loop(ctx, func(context.Context) { ticks <- struct{}{} })
+	}()
+
+	sendHead := func(n uint64) {
+		t.Helper()
+		select {
+		case mc.Heads <- chain.Header{Number: n}:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("head %d not consumed: no live subscription", n)
+		}
+		select {
+		case <-ticks:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no tick after head %d", n)
+		}
+	}
+
+	sendHead(1)
+	sendHead(2)
+
+	// drop the subscription; the loop must resubscribe and keep following heads
+	mc.Drops <- errors.New("connection reset")
+	sendHead(3)
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestLoop_PollsWhenSubscriptionUnavailable(t *testing.T) {
+	mc := chain.NewMock()
+	mc.SubscribeErr = errors.New("notifications not supported")
+	cfg := DefaultConfig()
+	cfg.PollInterval = 10 * time.Millisecond
+	svc := NewServiceWithStore(cfg, nil, mc)
+
+	ticks := make(chan struct{}, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = svc.procs[0].loop(ctx, func(context.Context) { ticks <- struct{}{} }) }()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-ticks:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected polling tick %d", i)
+		}
+	}
+}
+
+// subscribeCounter counts head subscriptions.
+type subscribeCounter struct {
+	*chain.MockClient
+	subs int32
+}
+
+func (c *subscribeCounter) SubscribeNewHeads(ctx context.Context, ch chan<- chain.Header) (chain.Subscription, error) {
+	atomic.AddInt32(&c.subs, 1)
+	return c.MockClient.SubscribeNewHeads(ctx, ch)
+}
+
+// A subscription that stays open but stops delivering heads must not stall processing.
+func TestLoop_ResubscribesWhenHeadsStop(t *testing.T) {
+	sc := &subscribeCounter{MockClient: chain.NewMock()}
+	cfg := DefaultConfig()
+	cfg.PollInterval = time.Hour
+	cfg.ResubscribeInterval = time.Hour
+	cfg.HeadTimeout = 20 * time.Millisecond
+	svc := NewServiceWithStore(cfg, nil, sc)
+
+	ticks := make(chan struct{}, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = svc.procs[0].loop(ctx, func(context.Context) { ticks <- struct{}{} }) }()
+
+	// no head ever arrives, yet cycles keep running
+	for i := 0; i < 2; i++ {
+		select {
+		case <-ticks:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected a tick %d from the silent subscription's timeout", i)
+		}
+	}
+	if n := atomic.LoadInt32(&sc.subs); n < 2 {
+		t.Fatalf("expected the silent subscription to be replaced, got %d subscriptions", n)
+	}
+}
@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/namtran/creditengine/internal/models"
+)
+
+// AssetNative names the chain's own currency in a ConfirmationTier.
+const AssetNative = "native"
+
+// ConfirmationPolicy tiers the confirmation requirement by asset and amount, so that a
+// small deposit can be credited sooner than a large one. Tiers are tried in order and the
+// first that matches a deposit applies; a deposit no tier matches is held to the chain's
+// Confirmations and FinalityMode. For example:
+//
+//	{"tiers": [
+//	  {"asset": "native", "below": 1000000000000000000, "confirmations": 6},
+//	  {"asset": "native", "below": 100000000000000000000, "confirmations": 12},
+//	  {"asset": "native", "finality": "finalized"}
+//	]}
+type ConfirmationPolicy struct {
+	Tiers []ConfirmationTier `json:"tiers"`
+}
+
+// ConfirmationTier is one band of a ConfirmationPolicy.
+type ConfirmationTier struct {
+	// Asset is a token contract address, AssetNative, or "" for any asset.
+	Asset string `json:"asset,omitempty"`
+	// Below makes the tier apply only to amounts strictly below it, in the asset's base
+	// units. Nil leaves the band open-ended.
+	Below *big.Int `json:"below,omitempty"`
+	// Confirmations required in FinalityConfirmations mode; 0 inherits the chain's.
+	Confirmations uint64 `json:"confirmations,omitempty"`
+	// Finality is the tier's finality mode; "" inherits the chain's FinalityMode.
+	Finality FinalityMode `json:"finality,omitempty"`
+}
+
+// LoadConfirmationPolicy reads a JSON ConfirmationPolicy from path.
+func LoadConfirmationPolicy(path string) (*ConfirmationPolicy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pol ConfirmationPolicy
+	if err := json.Unmarshal(b, &pol); err != nil {
+		return nil, fmt.Errorf("confirmation policy %s: %w", path, err)
+	}
+	if err := pol.Validate(); err != nil {
+		return nil, fmt.Errorf("confirmation policy %s: %w", path, err)
+	}
+	return &pol, nil
+}
+
+// Validate reports the first malformed tier.
+func (pol *ConfirmationPolicy) Validate() error {
+	for i, t := range pol.Tiers {
+		if t.Finality != "" && !t.Finality.valid() {
+			return fmt.Errorf("tier %d: unknown finality mode %q", i, t.Finality)
+		}
+		if t.Below != nil && t.Below.Sign() <= 0 {
+			return fmt.Errorf("tier %d: below must be positive", i)
+		}
+	}
+	return nil
+}
+
+// confirmationRule is what a deposit has to reach before it is credited.
+type confirmationRule struct {
+	tier          int // index into the policy's tiers, -1 for the chain default
+	confirmations uint64
+	mode          FinalityMode
+}
+
+// rule picks the confirmation rule for d from the chain's policy.
+func (p *chainProcessor) rule(d models.Deposit) confirmationRule {
+	if pol := p.cfg.ConfirmationPolicy; pol != nil {
+		asset := AssetNative
+		if d.Token.Valid {
+			asset = d.Token.String
+		}
+		amount := d.Amount.Big()
+		for i, t := range pol.Tiers {
+			if t.Asset != "" && !strings.EqualFold(t.Asset, asset) {
+				continue
+			}
+			if t.Below != nil && amount.Cmp(t.Below) >= 0 {
+				continue
+			}
+			r := confirmationRule{tier: i, confirmations: t.Confirmations, mode: t.Finality}
+			if r.confirmations == 0 {
+				r.confirmations = p.cfg.Confirmations
+			}
+			if r.mode == "" {
+				r.mode = p.cfg.FinalityMode
+			}
+			return r
+		}
+	}
+	return confirmationRule{tier: -1, confirmations: p.cfg.Confirmations, mode: p.cfg.FinalityMode}
+}
+
+// decision describes r for the audit of a credit.
+func (r confirmationRule) decision(pol *ConfirmationPolicy) *models.PolicyDecision {
+	dec := &models.PolicyDecision{Tier: r.tier, Mode: string(r.mode)}
+	if r.mode.tag() == "" {
+		dec.Confirmations = r.confirmations
+	}
+	if r.tier >= 0 {
+		t := pol.Tiers[r.tier]
+		dec.Asset = t.Asset
+		if t.Below != nil {
+			dec.Below = t.Below.String()
+		}
+	}
+	return dec
+}
+
+// maxConfirmations is the highest confirmation count the chain's policy can require.
+func (c *Config) maxConfirmations() uint64 {
+	n := c.Confirmations
+	if pol := c.ConfirmationPolicy; pol != nil {
+		for _, t := range pol.Tiers {
+			if t.Confirmations > n {
+				n = t.Confirmations
+			}
+		}
+	}
+	return n
+}
@@ -0,0 +1,136 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/namtran/creditengine/internal/chain"
+	"github.com/namtran/creditengine/internal/models"
+	st "github.com/namtran/creditengine/internal/store"
+)
+
+func TestConfirmationPolicy_PicksFirstMatchingTier(t *testing.T) {
+	pol, err := LoadConfirmationPolicy("testdata/confirmation_policy.json")
+	if err != nil {
+		t.Fatalf("LoadConfirmationPolicy: %v", err)
+	}
+	cfg := DefaultConfig()
+	cfg.ConfirmationPolicy = pol
+	p := newChainProcessor(cfg, nil, nil, nil)
+
+	usdc := sql.NullString{String: "0xUSDC", Valid: true}
+	dai := sql.NullString{String: "0xdai", Valid: true}
+	tests := []struct {
+		name string
+		d    models.Deposit
+		want confirmationRule
+	}{
+		{"small ETH", models.Deposit{Amount: models.Int64Amount(5e17)}, confirmationRule{tier: 0, confirmations: 6, mode: FinalityConfirmations}},
+		{"1 ETH is not below 1 ETH", models.Deposit{Amount: models.Int64Amount(1e18)}, confirmationRule{tier: 1, confirmations: 12, mode: FinalityConfirmations}},
+		{"small USDC, any case", models.Deposit{Token: usdc, Amount: models.Int64Amount(5e6)}, confirmationRule{tier: 3, confirmations: 3, mode: FinalityConfirmations}},
+		{"large USDC falls back", models.Deposit{Token: usdc, Amount: models.Int64Amount(5e10)}, confirmationRule{tier: -1, confirmations: 12, mode: FinalityConfirmations}},
+		{"unlisted token falls back", models.Deposit{Token: dai, Amount: models.Int64Amount(1)}, confirmationRule{tier: -1, confirmations: 12, mode: FinalityConfirmations}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.rule(tt.d); got != tt.want {
+				t.Fatalf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+	// amounts past int64 are out of reach of the scanner, but 100 ETH still parses
+	if pol.Tiers[1].Below.Cmp(new(big.Int).Mul(big.NewInt(100), big.NewInt(1e18))) != 0 {
+		t.Fatalf("unexpected bound %s", pol.Tiers[1].Below)
+	}
+}
+
+// A tier that only tightens the confirmation count must not drop a chain that waits for
+// finality back to counting confirmations.
+func TestConfirmationPolicy_TierInheritsChainFinality(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.FinalityMode = FinalitySafe
+	cfg.ConfirmationPolicy = &ConfirmationPolicy{Tiers: []ConfirmationTier{
+		{Asset: AssetNative, Below: big.NewInt(1e18), Confirmations: 6},
+		{Asset: AssetNative, Finality: FinalityFinalized},
+	}}
+	p := newChainProcessor(cfg, nil, nil, nil)
+
+	if got, want := p.rule(models.Deposit{Amount: models.Int64Amount(1e17)}), (confirmationRule{tier: 0, confirmations: 6, mode: FinalitySafe}); got != want {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+	if got, want := p.rule(models.Deposit{Amount: models.Int64Amount(1e18)}), (confirmationRule{tier: 1, confirmations: 12, mode: FinalityFinalized}); got != want {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestLoadConfirmationPolicy_RejectsUnknownMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(`{"tiers": [{"finality": "eventually"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfirmationPolicy(path); err == nil {
+		t.Fatalf("expected an error for an unknown finality mode")
+	}
+}
+
+// TestProcessOnce_AppliesAmountTiers credits a small deposit on confirmations while a
+// large one in the same block, with 21 confirmations, waits for the finalized head.
+func TestProcessOnce_AppliesAmountTiers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	mc := chain.NewMock()
+	mc.Block = 110
+	mc.Tags[chain.TagFinalized] = 80
+	for _, h := range []string{"0xsmall", "0xlarge"} {
+		mc.TxInfo[h] = struct {
+			Block    uint64
+			Hash     string
+			Reverted bool
+		}{Block: 90, Hash: "0xb90"}
+	}
+	mc.Blocks[90] = &chain.Block{Header: chain.Header{Number: 90, Hash: "0xb90"}, Txs: []chain.Tx{
+		{Hash: "0xsmall", To: "0xaddr", Value: big.NewInt(1e17)},
+		{Hash: "0xlarge", To: "0xaddr", Value: big.NewInt(9e18)},
+	}}
+
+	cfg := DefaultConfig()
+	cfg.ConfirmationPolicy = &ConfirmationPolicy{Tiers: []ConfirmationTier{
+		{Asset: AssetNative, Below: big.NewInt(1e18), Confirmations: 6},
+		{Asset: AssetNative, Finality: FinalityFinalized},
+	}}
+	svc := NewServiceWithStore(cfg, st.New(db), mc)
+
+	mock.ExpectQuery(pendingQuery).WithArgs(1, 76, sqlmock.AnyArg()).WillReturnRows(depositRows().
+		AddRow(1, 1, "0xsmall", -1, 0, "0xaddr", nil, int64(1e17), 5, 90, "0xb90", "pending", time.Now(), false, nil).
+		AddRow(2, 1, "0xlarge", -1, 0, "0xaddr", nil, int64(9e18), 5, 90, "0xb90", "pending", time.Now(), false, nil))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET tx_block = $1, block_hash = $2 WHERE id = $3")).WithArgs(90, "0xb90", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET confirmations = $1 WHERE id = $2")).WithArgs(21, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	expectTransition(mock, 1, models.StatusPending, models.StatusCredited)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE accounts SET balance = balance + $1 WHERE chain_id = $2 AND address = $3")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET credited_at = $1 WHERE id = $2")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audits(deposit_id, action, details, created_at) VALUES($1, $2, $3, $4)")).
+		WithArgs(1, "credited", jsonContains{`"policy":{"tier":0,"asset":"native","below":"1000000000000000000","mode":"confirmations","confirmations":6}`}, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	// the large deposit's block is not finalized yet
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET tx_block = $1, block_hash = $2 WHERE id = $3")).WithArgs(90, "0xb90", 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET confirmations = $1 WHERE id = $2")).WithArgs(21, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := svc.ProcessOnce(context.Background()); err != nil {
+		t.Fatalf("ProcessOnce error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/namtran/creditengine/internal/chain"
+	"github.com/namtran/creditengine/internal/models"
+)
+
+// txStatus is what the chain says about a deposit's transaction.
+type txStatus struct {
+	txBlock       uint64
+	confirmations uint64
+	blockHash     string
+	found         bool
+	reverted      bool
+}
+
+// txKey is what deposit d's transaction is looked up and keyed by: its tx hash, or on
+// Bitcoin its txid:vout outpoint, so that the client also checks the output exists.
+func (p *chainProcessor) txKey(d models.Deposit) string {
+	if p.cfg.Bitcoin && d.LogIndex >= 0 {
+		return chain.Outpoint(d.TxHash, uint32(d.LogIndex))
+	}
+	return d.TxHash
+}
+
+// txStatuses looks up the transactions behind deposits, once per distinct txKey. With a
+// ReceiptBatcher all receipts come from batched calls, with confirmations computed
+// locally against head; otherwise each transaction costs a
+// ConfirmationsFromTxHash call, Config.Workers of them at a time. Transactions whose
+// lookup failed are absent from the result and are retried next cycle.
+func (p *chainProcessor) txStatuses(ctx context.Context, deposits []models.Deposit, head uint64) (map[string]txStatus, error) {
+	hashes := make([]string, 0, len(deposits))
+	seen := make(map[string]bool, len(deposits))
+	for _, d := range deposits {
+		if k := p.txKey(d); !seen[k] {
+			seen[k] = true
+			hashes = append(hashes, k)
+		}
+	}
+
+	if b, ok := p.chain.(chain.ReceiptBatcher); ok {
+		res, err := p.batchTxStatuses(ctx, b, hashes, head)
+		if !errors.Is(err, chain.ErrUnsupported) {
+			return res, err
+		}
+	}
+
+	// the lookups run on the worker pool; an open circuit stops the ones not yet started
+	lookupCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	var circuitErr error
+	res := make(map[string]txStatus, len(hashes))
+	runBounded(lookupCtx, p.workers(), len(hashes), func(i int) {
+		if lookupCtx.Err() != nil {
+			return
+		}
+		h := hashes[i]
+		txBlock, conf, blockHash, found, reverted, err := p.chain.ConfirmationsFromTxHash(lookupCtx, h)
+		mu.Lock()
+		defer mu.Unlock()
+		if errors.Is(err, chain.ErrCircuitOpen) {
+			// the node is unhealthy; stop the cycle and leave every deposit untouched
+			circuitErr = err
+			cancel()
+			return
+		}
+		if err != nil {
+			log.Printf("chain error for %s: %v", h, err)
+			return
+		}
+		res[h] = txStatus{txBlock: txBlock, confirmations: conf, blockHash: blockHash, found: found, reverted: reverted}
+	})
+	if circuitErr != nil {
+		return nil, circuitErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (p *chainProcessor) batchTxStatuses(ctx context.Context, b chain.ReceiptBatcher, hashes []string, head uint64) (map[string]txStatus, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	recs, err := b.Receipts(ctx, hashes)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]txStatus, len(recs))
+	for h, r := range recs {
+		if r == nil {
+			res[h] = txStatus{}
+			continue
+		}
+		var conf uint64
+		if head >= r.BlockNumber {
+			conf = head - r.BlockNumber + 1
+		}
+		res[h] = txStatus{txBlock: r.BlockNumber, confirmations: conf, blockHash: r.BlockHash, found: true, reverted: r.Reverted}
+	}
+	return res, nil
+}
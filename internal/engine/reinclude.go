@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/namtran/creditengine/internal/chain"
+	"github.com/namtran/creditengine/internal/models"
+)
+
+// reorgedSince is the cutoff before which reorged deposits are no longer re-checked.
+func (p *chainProcessor) reorgedSince() time.Time {
+	return time.Now().Add(-p.cfg.ReinclusionWindow)
+}
+
+// recheckReorged looks for a reorged deposit's transaction on the canonical chain. Most
+// reorged transactions are mined again within a few blocks; when this one is, and its
+// transfer at the deposit's position still matches the row, the deposit goes back to
+// pending at the new block and is credited through the usual confirmation policy.
+//
+// A token transfer usually lands at a different log index in its new block. The scanner
+// then records it as a new deposit, so the old row only matches (and is re-included) when
+// the log kept its index, which is exactly when the scanner's insert conflicted with it.
+func (p *chainProcessor) recheckReorged(ctx context.Context, d models.Deposit, st txStatus) {
+	if !st.found || st.reverted {
+		return
+	}
+	mismatch, err := p.verifyDeposit(ctx, d, st)
+	switch {
+	case errors.Is(err, chain.ErrUnsupported):
+		// the receipt is all there is to go on
+	case err != nil:
+		log.Printf("failed to check re-inclusion of %s: %v", d.TxHash, err)
+		return
+	case mismatch != nil:
+		return
+	}
+
+	ok, err := p.store.MarkDepositReincluded(ctx, d.ID, models.Reinclusion{
+		TxBlock:       uint64(d.TxBlock.Int64),
+		BlockHash:     d.BlockHash.String,
+		NewTxBlock:    st.txBlock,
+		NewBlockHash:  st.blockHash,
+		Confirmations: st.confirmations,
+	})
+	if err != nil {
+		log.Printf("failed to re-include deposit %s: %v", d.TxHash, err)
+		return
+	}
+	if ok {
+		log.Printf("chain %d deposit %s re-included at block %d, pending again", p.cfg.ChainID, d.TxHash, st.txBlock)
+	}
+}
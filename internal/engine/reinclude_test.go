@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/namtran/creditengine/internal/chain"
+	st "github.com/namtran/creditengine/internal/store"
+)
+
+// TestProcessOnce_LeavesMovedTokenLogReorged re-mines a token transfer at another log
+// index. The scanner records that log as a new deposit, so the old row must stay reorged.
+func TestProcessOnce_LeavesMovedTokenLogReorged(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	mc := chain.NewMock()
+	mc.Block = 100
+	mc.TxInfo["0xtok"] = struct {
+		Block    uint64
+		Hash     string
+		Reverted bool
+	}{Block: 97, Hash: "0xb97"}
+	mc.Logs = []chain.TransferLog{{TxHash: "0xtok", LogIndex: 5, BlockNumber: 97, BlockHash: "0xb97", Token: "0xusdc", To: "0xaddr", Value: big.NewInt(50)}}
+
+	mock.ExpectQuery(pendingQuery).WillReturnRows(depositRows().AddRow(1, 1, "0xtok", 2, 0, "0xaddr", "0xusdc", 50, 3, 90, "0xb90", "reorged", time.Now(), false, nil))
+
+	cfg := DefaultConfig()
+	cfg.Tokens = []string{"0xusdc"}
+	svc := NewServiceWithStore(cfg, st.New(db), mc)
+	if err := svc.ProcessOnce(context.Background()); err != nil {
+		t.Fatalf("ProcessOnce error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
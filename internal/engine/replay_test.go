@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/namtran/creditengine/internal/chain"
+	"github.com/namtran/creditengine/internal/models"
+	st "github.com/namtran/creditengine/internal/store"
+)
+
+// TestProcessOnce_ReplaysRecordedIncident replays a recorded provider session: the first
+// receipt batch fails with a 502, and on the retry one deposit's transaction has moved to
+// another block.
+func TestProcessOnce_ReplaysRecordedIncident(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	pending := func() *sqlmock.Rows {
+		return depositRows().
+			AddRow(1, 1, "0xabc", -1, 0, "0xaddr", nil, 1000, 11, 90, "0xhash", "pending", time.Now(), false, nil).
+			AddRow(2, 1, "0xdef", -1, 0, "0xaddr", nil, 500, 7, 95, "0xb95", "pending", time.Now(), false, nil)
+	}
+	// cycle 1: the receipt batch fails and nothing is touched
+	mock.ExpectQuery(pendingQuery).WillReturnRows(pending())
+	// cycle 2
+	mock.ExpectQuery(pendingQuery).WillReturnRows(pending())
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET tx_block = $1, block_hash = $2 WHERE id = $3")).WithArgs(90, "0xhash", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET confirmations = $1 WHERE id = $2")).WithArgs(13, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	expectTransition(mock, 1, models.StatusPending, models.StatusCredited)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE accounts SET balance = balance + $1 WHERE chain_id = $2 AND address = $3")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET credited_at = $1 WHERE id = $2")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audits(deposit_id, action, details, created_at) VALUES($1, $2, $3, $4)")).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	expectTransition(mock, 2, models.StatusPending, models.StatusReorged)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET reorged_at = $1 WHERE id = $2")).WithArgs(sqlmock.AnyArg(), 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audits(deposit_id, action, created_at) VALUES($1, $2, $3)")).WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	rp, err := chain.LoadReplay("testdata/receipt_moved_after_502.jsonl")
+	if err != nil {
+		t.Fatalf("LoadReplay: %v", err)
+	}
+	svc := NewServiceWithStore(DefaultConfig(), st.New(db), rp)
+	ctx := context.Background()
+
+	if err := svc.ProcessOnce(ctx); !chain.IsTransient(err) {
+		t.Fatalf("expected the recorded 502 as a transient error, got %v", err)
+	}
+	if err := svc.ProcessOnce(ctx); err != nil {
+		t.Fatalf("ProcessOnce error: %v", err)
+	}
+	if n := rp.Remaining(); n != 0 {
+		t.Fatalf("%d recorded responses were not replayed", n)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,282 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/namtran/creditengine/internal/chain"
+	"github.com/namtran/creditengine/internal/models"
+	"github.com/namtran/creditengine/internal/store"
+)
+
+// checkpointName identifies the deposit scanner's row in scan_checkpoints.
+const checkpointName = "deposits"
+
+// Scanner walks new blocks and records pending deposits for transactions, ERC-20
+// transfers of allowlisted tokens (Config.Tokens) and, with Config.InternalTransfers,
+// contract-originated ETH transfers, sent to known account addresses. Its position is
+// persisted as a checkpoint that advances atomically with each block's deposits, so a
+// restarted engine resumes where it stopped.
+type Scanner struct {
+	cfg     *Config
+	store   *store.Store
+	chain   chain.ChainClient
+	resumed bool
+	// noTraces is set once the node turned out to serve no tracing API.
+	noTraces bool
+	// last is the most recent committed block; nil until the first block is scanned.
+	last *models.Checkpoint
+}
+
+// NewScanner creates a Scanner for the given store and chain client.
+func NewScanner(cfg *Config, s *store.Store, ch chain.ChainClient) *Scanner {
+	return &Scanner{cfg: cfg, store: s, chain: ch}
+}
+
+// ScanOnce scans from the block after the checkpoint up to the head, at most
+// Config.ScanBatchSize blocks per call. Without a checkpoint it starts at
+// Config.ScanStartBlock, or at the current head when that is 0. It is a no-op when the
+// chain client cannot return full blocks.
+func (sc *Scanner) ScanOnce(ctx context.Context) error {
+	if _, ok := sc.chain.(chain.BlockSource); !ok {
+		return nil
+	}
+	head, err := sc.chain.BlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	return sc.scanTo(ctx, head)
+}
+
+// scanTo is ScanOnce up to a head the caller has already read.
+func (sc *Scanner) scanTo(ctx context.Context, head uint64) error {
+	if err := sc.scan(ctx, head); !errors.Is(err, chain.ErrUnsupported) {
+		return err
+	}
+	return nil
+}
+
+func (sc *Scanner) scan(ctx context.Context, head uint64) error {
+	src, ok := sc.chain.(chain.BlockSource)
+	if !ok {
+		return nil
+	}
+	if !sc.resumed {
+		if err := sc.resume(ctx, src); err != nil {
+			return err
+		}
+	}
+	next := sc.cfg.ScanStartBlock
+	if sc.last != nil {
+		next = sc.last.BlockNumber + 1
+	} else if next == 0 {
+		next = head
+	}
+	if next > head {
+		return nil
+	}
+	to := head
+	if sc.cfg.ScanBatchSize > 0 && to-next+1 > sc.cfg.ScanBatchSize {
+		to = next + sc.cfg.ScanBatchSize - 1
+	}
+
+	addrs, err := sc.store.AccountAddresses(ctx, sc.cfg.ChainID)
+	if err != nil {
+		return err
+	}
+	var fwds map[string]string
+	if sc.cfg.forwarders() {
+		if fwds, err = sc.store.ForwarderAddresses(ctx, sc.cfg.ChainID); err != nil {
+			return err
+		}
+	}
+	tokenDeposits, err := sc.tokenDeposits(ctx, next, to, addrs, fwds)
+	if err != nil {
+		return err
+	}
+	for n := next; n <= to; n++ {
+		b, err := src.BlockByNumber(ctx, n)
+		if err != nil {
+			return err
+		}
+		if sc.last != nil && b.ParentHash != sc.last.BlockHash {
+			// the chain reorganised under us; rewind and rescan on the next cycle
+			return sc.rewind(ctx, src)
+		}
+		cp := models.Checkpoint{ChainID: sc.cfg.ChainID, Name: checkpointName, BlockNumber: b.Number, BlockHash: b.Hash, ParentHash: b.ParentHash}
+		deps := append(matchNativeDeposits(sc.cfg.ChainID, b, addrs), tokenDeposits[n]...)
+		internal, err := sc.internalDeposits(ctx, b, addrs)
+		if err != nil {
+			return err
+		}
+		deps = append(deps, internal...)
+		if err := sc.store.CommitBlock(ctx, cp, deps, sc.cfg.ReorgWindow); err != nil {
+			return err
+		}
+		sc.last = &cp
+	}
+	return nil
+}
+
+// resume loads the persisted checkpoint and, if its block is no longer canonical, walks
+// back to the common ancestor before scanning continues.
+func (sc *Scanner) resume(ctx context.Context, src chain.BlockSource) error {
+	cp, err := sc.store.LoadCheckpoint(ctx, sc.cfg.ChainID, checkpointName)
+	if err != nil {
+		return err
+	}
+	sc.last = cp
+	if cp != nil {
+		h, err := src.HeaderByNumber(ctx, cp.BlockNumber)
+		if err != nil {
+			return err
+		}
+		if h.Hash != cp.BlockHash {
+			if err := sc.rewind(ctx, src); err != nil {
+				return err
+			}
+		}
+	}
+	sc.resumed = true
+	return nil
+}
+
+// rewind walks back from the checkpoint until a scanned block hash matches the canonical
+// chain and moves the checkpoint there. Deposits in the orphaned blocks stay pending; the
+// per-deposit receipt checks in ProcessOnce settle them.
+func (sc *Scanner) rewind(ctx context.Context, src chain.BlockSource) error {
+	for n := sc.last.BlockNumber; n > 0; n-- {
+		stored, ok, err := sc.store.ScannedBlockHash(ctx, sc.cfg.ChainID, n)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		h, err := src.HeaderByNumber(ctx, n)
+		if err != nil {
+			return err
+		}
+		if h.Hash != stored {
+			continue
+		}
+		cp := models.Checkpoint{ChainID: sc.cfg.ChainID, Name: checkpointName, BlockNumber: h.Number, BlockHash: h.Hash, ParentHash: h.ParentHash}
+		if err := sc.store.RewindCheckpoint(ctx, cp); err != nil {
+			return err
+		}
+		log.Printf("scanner: chain %d reorg detected, rewound checkpoint from %d to %d", sc.cfg.ChainID, sc.last.BlockNumber, n)
+		sc.last = &cp
+		return nil
+	}
+	return fmt.Errorf("scanner: chain %d: no common ancestor within the last %d scanned blocks of %d", sc.cfg.ChainID, sc.cfg.ReorgWindow, sc.last.BlockNumber)
+}
+
+// matchNativeDeposits returns a pending deposit for every value transfer in b whose
+// recipient is one of addrs (keyed by lower-cased address).
+func matchNativeDeposits(chainID uint64, b *chain.Block, addrs map[string]string) []models.Deposit {
+	var res []models.Deposit
+	for _, tx := range b.Txs {
+		addr, ok := addrs[tx.To]
+		if !ok || tx.Value == nil || tx.Value.Sign() <= 0 {
+			continue
+		}
+		res = append(res, models.Deposit{
+			ChainID:   chainID,
+			TxHash:    tx.Hash,
+			LogIndex:  -1,
+			Address:   addr,
+			Amount:    models.NewAmount(tx.Value),
+			TxBlock:   sql.NullInt64{Int64: int64(b.Number), Valid: true},
+			BlockHash: sql.NullString{String: b.Hash, Valid: true},
+			Status:    models.StatusPending,
+		})
+	}
+	return res
+}
+
+// internalDeposits traces b and returns a pending deposit for every value transfer a
+// contract made to one of addrs. It is a no-op unless Config.InternalTransfers is set and
+// the node serves a tracing API.
+func (sc *Scanner) internalDeposits(ctx context.Context, b *chain.Block, addrs map[string]string) ([]models.Deposit, error) {
+	src, ok := sc.chain.(chain.TraceSource)
+	if !ok || !sc.cfg.InternalTransfers || sc.noTraces {
+		return nil, nil
+	}
+	transfers, err := src.InternalTransfers(ctx, b.Number, b.Hash)
+	if errors.Is(err, chain.ErrUnsupported) {
+		log.Printf("scanner: chain %d node serves no tracing API, internal transfers are not detected", sc.cfg.ChainID)
+		sc.noTraces = true
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var res []models.Deposit
+	for _, t := range transfers {
+		addr, ok := addrs[t.To]
+		if !ok || t.Value == nil || t.Value.Sign() <= 0 {
+			continue
+		}
+		res = append(res, models.Deposit{
+			ChainID:    sc.cfg.ChainID,
+			TxHash:     t.TxHash,
+			LogIndex:   -1,
+			TraceIndex: t.Index,
+			Address:    addr,
+			Amount:     models.NewAmount(t.Value),
+			TxBlock:    sql.NullInt64{Int64: int64(b.Number), Valid: true},
+			BlockHash:  sql.NullString{String: b.Hash, Valid: true},
+			Status:     models.StatusPending,
+		})
+	}
+	return res, nil
+}
+
+// tokenDeposits fetches Transfer events of the allowlisted tokens in [from, to] and
+// returns the ones paying a known address, grouped by block number. Each log becomes its
+// own deposit, so a multisend paying several accounts yields several deposits. Transfers
+// to an account's forwarder (fwds) are credited to the account and flagged for flushing,
+// whether or not the forwarder has been deployed yet.
+func (sc *Scanner) tokenDeposits(ctx context.Context, from, to uint64, addrs, fwds map[string]string) (map[uint64][]models.Deposit, error) {
+	src, ok := sc.chain.(chain.LogSource)
+	if !ok || len(sc.cfg.Tokens) == 0 {
+		return nil, nil
+	}
+	logs, err := src.TransferLogs(ctx, from, to, sc.cfg.Tokens)
+	if errors.Is(err, chain.ErrUnsupported) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[uint64][]models.Deposit)
+	for _, l := range logs {
+		addr, ok := addrs[l.To]
+		viaForwarder := false
+		if !ok {
+			addr, viaForwarder = fwds[l.To]
+			ok = viaForwarder
+		}
+		if !ok || l.Value == nil || l.Value.Sign() <= 0 {
+			continue
+		}
+		res[l.BlockNumber] = append(res[l.BlockNumber], models.Deposit{
+			ChainID:    sc.cfg.ChainID,
+			TxHash:     l.TxHash,
+			LogIndex:   int64(l.LogIndex),
+			Address:    addr,
+			Token:      sql.NullString{String: strings.ToLower(l.Token), Valid: true},
+			Amount:     models.NewAmount(l.Value),
+			TxBlock:    sql.NullInt64{Int64: int64(l.BlockNumber), Valid: true},
+			BlockHash:  sql.NullString{String: l.BlockHash, Valid: true},
+			Status:     models.StatusPending,
+			NeedsFlush: viaForwarder,
+			Recipient:  sql.NullString{String: l.To, Valid: viaForwarder},
+		})
+	}
+	return res, nil
+}
@@ -0,0 +1,238 @@
+package engine
+
+import (
+	"context"
+	"math/big"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/namtran/creditengine/internal/chain"
+	"github.com/namtran/creditengine/internal/models"
+	st "github.com/namtran/creditengine/internal/store"
+)
+
+func TestScanOnce_InsertsDepositsToKnownAddresses(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	expectCheckpoint(mock, nil)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT address FROM accounts WHERE chain_id = $1")).WillReturnRows(sqlmock.NewRows([]string{"address"}).AddRow("0xAbC0000000000000000000000000000000000001"))
+	mock.ExpectBegin()
+	expectBlockCommitted(mock, 100, "0xb100")
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO deposits(chain_id, tx_hash, log_index, trace_index, address, token, amount, tx_block, block_hash, needs_flush, recipient, status) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, 'pending') ON CONFLICT (chain_id, tx_hash, log_index, trace_index) DO NOTHING")).
+		WithArgs(1, "0xt1", int64(-1), int64(0), "0xAbC0000000000000000000000000000000000001", nil, "500", int64(101), "0xb101", false, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	// 50 ETH is past int64 wei and is recorded in full
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO deposits(chain_id, tx_hash, log_index, trace_index, address, token, amount, tx_block, block_hash, needs_flush, recipient, status) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, 'pending') ON CONFLICT (chain_id, tx_hash, log_index, trace_index) DO NOTHING")).
+		WithArgs(1, "0xt4", int64(-1), int64(0), "0xAbC0000000000000000000000000000000000001", nil, "50000000000000000000", int64(101), "0xb101", false, nil).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	expectBlockCommitted(mock, 101, "0xb101")
+
+	mc := chain.NewMock()
+	mc.Block = 101
+	mc.Blocks[100] = &chain.Block{Header: chain.Header{Number: 100, Hash: "0xb100"}}
+	mc.Blocks[101] = &chain.Block{
+		Header: chain.Header{Number: 101, Hash: "0xb101", ParentHash: "0xb100"},
+		Txs: []chain.Tx{
+			{Hash: "0xt1", To: "0xabc0000000000000000000000000000000000001", Value: big.NewInt(500)},
+			{Hash: "0xt2", To: "0xother", Value: big.NewInt(700)},
+			{Hash: "0xt3", To: "", Value: big.NewInt(0)},
+			{Hash: "0xt4", To: "0xabc0000000000000000000000000000000000001", Value: new(big.Int).Mul(big.NewInt(50), big.NewInt(1e18))},
+		},
+	}
+
+	cfg := DefaultConfig()
+	cfg.ScanStartBlock = 100
+	sc := NewScanner(cfg, st.New(db), mc)
+	if err := sc.ScanOnce(context.Background()); err != nil {
+		t.Fatalf("ScanOnce error: %v", err)
+	}
+	if sc.last == nil || sc.last.BlockNumber != 101 {
+		t.Fatalf("expected checkpoint at block 101, got %+v", sc.last)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestScanOnce_SplitsMultisendIntoTokenDeposits(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	expectCheckpoint(mock, nil)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT address FROM accounts WHERE chain_id = $1")).WillReturnRows(sqlmock.NewRows([]string{"address"}).AddRow("0xa1").AddRow("0xa2"))
+	mock.ExpectBegin()
+	insert := regexp.QuoteMeta("INSERT INTO deposits(chain_id, tx_hash, log_index, trace_index, address, token, amount, tx_block, block_hash, needs_flush, recipient, status)")
+	mock.ExpectExec(insert).WithArgs(1, "0xmulti", int64(3), int64(0), "0xa1", "0xusdc", "10", int64(50), "0xb50", false, nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(insert).WithArgs(1, "0xmulti", int64(4), int64(0), "0xa2", "0xusdc", "20", int64(50), "0xb50", false, nil).WillReturnResult(sqlmock.NewResult(2, 1))
+	// 1000 units of an 18-decimal token is past int64 and is recorded in full
+	mock.ExpectExec(insert).WithArgs(1, "0xmulti", int64(6), int64(0), "0xa2", "0xusdc", "1000000000000000000000", int64(50), "0xb50", false, nil).WillReturnResult(sqlmock.NewResult(3, 1))
+	expectBlockCommitted(mock, 50, "0xb50")
+
+	mc := chain.NewMock()
+	mc.Block = 50
+	mc.Blocks[50] = &chain.Block{Header: chain.Header{Number: 50, Hash: "0xb50"}}
+	mc.Logs = []chain.TransferLog{
+		{TxHash: "0xmulti", LogIndex: 3, BlockNumber: 50, BlockHash: "0xb50", Token: "0xusdc", To: "0xa1", Value: big.NewInt(10)},
+		{TxHash: "0xmulti", LogIndex: 4, BlockNumber: 50, BlockHash: "0xb50", Token: "0xusdc", To: "0xa2", Value: big.NewInt(20)},
+		{TxHash: "0xmulti", LogIndex: 5, BlockNumber: 50, BlockHash: "0xb50", Token: "0xusdc", To: "0xstranger", Value: big.NewInt(30)},
+		{TxHash: "0xmulti", LogIndex: 6, BlockNumber: 50, BlockHash: "0xb50", Token: "0xusdc", To: "0xa2", Value: new(big.Int).Mul(big.NewInt(1000), big.NewInt(1e18))},
+		{TxHash: "0xother", LogIndex: 0, BlockNumber: 50, BlockHash: "0xb50", Token: "0xscam", To: "0xa1", Value: big.NewInt(99)},
+	}
+
+	cfg := DefaultConfig()
+	cfg.ScanStartBlock = 50
+	cfg.Tokens = []string{"0xUSDC"}
+	if err := NewScanner(cfg, st.New(db), mc).ScanOnce(context.Background()); err != nil {
+		t.Fatalf("ScanOnce error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestScanOnce_CreditsTokenTransfersToForwarders(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	expectCheckpoint(mock, nil)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT address FROM accounts WHERE chain_id = $1")).WillReturnRows(sqlmock.NewRows([]string{"address"}).AddRow("0xa1"))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT forwarder_address, address FROM accounts WHERE chain_id = $1 AND forwarder_address IS NOT NULL")).
+		WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"forwarder_address", "address"}).AddRow("0xF1", "0xa1"))
+	mock.ExpectBegin()
+	insert := regexp.QuoteMeta("INSERT INTO deposits(chain_id, tx_hash, log_index, trace_index, address, token, amount, tx_block, block_hash, needs_flush, recipient, status)")
+	mock.ExpectExec(insert).WithArgs(1, "0xdirect", int64(0), int64(0), "0xa1", "0xusdc", "10", int64(70), "0xb70", false, nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	// the forwarder has no code yet; the deposit is still the account's
+	mock.ExpectExec(insert).WithArgs(1, "0xfwd", int64(1), int64(0), "0xa1", "0xusdc", "20", int64(70), "0xb70", true, "0xf1").WillReturnResult(sqlmock.NewResult(2, 1))
+	expectBlockCommitted(mock, 70, "0xb70")
+
+	mc := chain.NewMock()
+	mc.Block = 70
+	mc.Blocks[70] = &chain.Block{Header: chain.Header{Number: 70, Hash: "0xb70"}}
+	mc.Logs = []chain.TransferLog{
+		{TxHash: "0xdirect", LogIndex: 0, BlockNumber: 70, BlockHash: "0xb70", Token: "0xusdc", To: "0xa1", Value: big.NewInt(10)},
+		{TxHash: "0xfwd", LogIndex: 1, BlockNumber: 70, BlockHash: "0xb70", Token: "0xusdc", To: "0xf1", Value: big.NewInt(20)},
+	}
+
+	cfg := DefaultConfig()
+	cfg.ScanStartBlock = 70
+	cfg.Tokens = []string{"0xusdc"}
+	cfg.ForwarderFactory = "0xdeadbeef00000000000000000000000000000000"
+	cfg.ForwarderInitCodeHash = "0xbc36789e7a1e281436464229828f817d6612f7b477d66591ff96a9e064bcc98a"
+	if err := NewScanner(cfg, st.New(db), mc).ScanOnce(context.Background()); err != nil {
+		t.Fatalf("ScanOnce error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestScanOnce_RecordsInternalTransfers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	expectCheckpoint(mock, nil)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT address FROM accounts WHERE chain_id = $1")).WillReturnRows(sqlmock.NewRows([]string{"address"}).AddRow("0xa1"))
+	mock.ExpectBegin()
+	// the top-level call went to a smart wallet; only the wallet's inner call paid us
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO deposits(chain_id, tx_hash, log_index, trace_index, address, token, amount, tx_block, block_hash, needs_flush, recipient, status)")).
+		WithArgs(1, "0xviawallet", int64(-1), int64(2), "0xa1", nil, "40", int64(60), "0xb60", false, nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	// a sweep of 20 ETH through the wallet is past int64 and is recorded in full
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO deposits(chain_id, tx_hash, log_index, trace_index, address, token, amount, tx_block, block_hash, needs_flush, recipient, status)")).
+		WithArgs(1, "0xviawallet", int64(-1), int64(4), "0xa1", nil, "20000000000000000000", int64(60), "0xb60", false, nil).WillReturnResult(sqlmock.NewResult(2, 1))
+	expectBlockCommitted(mock, 60, "0xb60")
+
+	mc := chain.NewMock()
+	mc.Block = 60
+	mc.Blocks[60] = &chain.Block{
+		Header: chain.Header{Number: 60, Hash: "0xb60"},
+		Txs:    []chain.Tx{{Hash: "0xviawallet", To: "0xwallet", Value: big.NewInt(40)}},
+	}
+	mc.Traces[60] = []chain.InternalTransfer{
+		{TxHash: "0xviawallet", Index: 2, From: "0xwallet", To: "0xa1", Value: big.NewInt(40)},
+		{TxHash: "0xviawallet", Index: 3, From: "0xwallet", To: "0xstranger", Value: big.NewInt(1)},
+		{TxHash: "0xviawallet", Index: 4, From: "0xwallet", To: "0xa1", Value: new(big.Int).Mul(big.NewInt(20), big.NewInt(1e18))},
+	}
+
+	cfg := DefaultConfig()
+	cfg.ScanStartBlock = 60
+	cfg.InternalTransfers = true
+	if err := NewScanner(cfg, st.New(db), mc).ScanOnce(context.Background()); err != nil {
+		t.Fatalf("ScanOnce error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestScanOnce_ResumesFromCommonAncestorAfterReorg(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	// checkpoint points at a block that is no longer canonical
+	expectCheckpoint(mock, &models.Checkpoint{BlockNumber: 101, BlockHash: "0xold101"})
+	scannedHash := regexp.QuoteMeta("SELECT hash FROM scanned_blocks WHERE chain_id = $1 AND number = $2")
+	mock.ExpectQuery(scannedHash).WithArgs(1, 101).WillReturnRows(sqlmock.NewRows([]string{"hash"}).AddRow("0xold101"))
+	mock.ExpectQuery(scannedHash).WithArgs(1, 100).WillReturnRows(sqlmock.NewRows([]string{"hash"}).AddRow("0xb100"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM scanned_blocks WHERE chain_id = $1 AND number > $2")).WithArgs(1, 100).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO scan_checkpoints(chain_id, name, block_number, block_hash, updated_at)")).WithArgs(1, checkpointName, 100, "0xb100").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	// then the canonical block 101 is scanned
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT address FROM accounts WHERE chain_id = $1")).WillReturnRows(sqlmock.NewRows([]string{"address"}))
+	mock.ExpectBegin()
+	expectBlockCommitted(mock, 101, "0xb101")
+
+	mc := chain.NewMock()
+	mc.Block = 101
+	mc.Blocks[100] = &chain.Block{Header: chain.Header{Number: 100, Hash: "0xb100"}}
+	mc.Blocks[101] = &chain.Block{Header: chain.Header{Number: 101, Hash: "0xb101", ParentHash: "0xb100"}}
+
+	sc := NewScanner(DefaultConfig(), st.New(db), mc)
+	if err := sc.ScanOnce(context.Background()); err != nil {
+		t.Fatalf("ScanOnce error: %v", err)
+	}
+	if sc.last == nil || sc.last.BlockHash != "0xb101" {
+		t.Fatalf("expected checkpoint at canonical block 101, got %+v", sc.last)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func expectCheckpoint(mock sqlmock.Sqlmock, cp *models.Checkpoint) {
+	rows := sqlmock.NewRows([]string{"block_number", "block_hash"})
+	if cp != nil {
+		rows.AddRow(cp.BlockNumber, cp.BlockHash)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT block_number, block_hash FROM scan_checkpoints WHERE chain_id = $1 AND name = $2")).WithArgs(1, checkpointName).WillReturnRows(rows)
+}
+
+// expectBlockCommitted expects the tail of Store.CommitBlock after the deposit inserts.
+func expectBlockCommitted(mock sqlmock.Sqlmock, number int, hash string) {
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO scanned_blocks(chain_id, number, hash, parent_hash)")).WithArgs(1, number, hash, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO scan_checkpoints(chain_id, name, block_number, block_hash, updated_at)")).WithArgs(1, checkpointName, number, hash).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
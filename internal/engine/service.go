@@ -3,132 +3,530 @@ package engine
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/namtran/creditengine/internal/chain"
+	"github.com/namtran/creditengine/internal/models"
 	"github.com/namtran/creditengine/internal/store"
 )
 
 // Service is a small orchestrator that polls deposits and credits accounts when final.
+// It runs one chainProcessor per configured chain.
 type Service struct {
-	cfg   *Config
-	db    *sql.DB
-	store *store.Store
-	chain chain.ChainClient
+	cfg    *Config
+	db     *sql.DB
+	store  *store.Store
+	chains *chain.Registry
+	procs  []*chainProcessor
 }
 
-// NewService constructs a Service with real DB and optional chain client.
+// chainProcessor runs the scan, reorg and credit pipeline for one chain. Its cfg is that
+// chain's view of the Config (see Config.chainConfigs).
+type chainProcessor struct {
+	cfg     *Config
+	store   *store.Store
+	chain   chain.ChainClient
+	scanner *Scanner
+	tracker *chain.HeaderTracker
+	heads   chain.HeadSubscriber
+	alerter Alerter
+}
+
+func newChainProcessor(cfg *Config, s *store.Store, ch chain.ChainClient, heads chain.HeadSubscriber) *chainProcessor {
+	return &chainProcessor{cfg: cfg, store: s, chain: ch, scanner: NewScanner(cfg, s, ch), tracker: chain.NewHeaderTracker(int(cfg.ReorgWindow)), heads: heads, alerter: newAlerter(cfg)}
+}
+
+// NewService constructs a Service with real DB and a chain client per configured chain.
 func NewService(cfg *Config) (*Service, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
 	db, err := sql.Open("postgres", cfg.PostgresDSN)
 	if err != nil {
 		return nil, err
 	}
 	st := store.New(db)
-	ch, _ := chain.New(cfg.RPCUrl)
-	return &Service{cfg: cfg, db: db, store: st, chain: ch}, nil
+	svc := &Service{cfg: cfg, db: db, store: st, chains: chain.NewRegistry()}
+	for _, ccfg := range cfg.chainConfigs() {
+		p, err := dialProcessor(ccfg, st)
+		if err != nil {
+			return nil, fmt.Errorf("chain %d: %w", ccfg.ChainID, err)
+		}
+		if err := svc.chains.Register(ccfg.ChainID, p.chain); err != nil {
+			return nil, err
+		}
+		svc.procs = append(svc.procs, p)
+	}
+	return svc, nil
+}
+
+// dialProcessor connects to one chain's endpoints and builds its processor.
+func dialProcessor(cfg *Config, st *store.Store) (*chainProcessor, error) {
+	inner, err := dialChain(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.l2() {
+		if inner, err = wrapL2(cfg, inner); err != nil {
+			return nil, err
+		}
+	}
+	rc := chain.NewResilient(inner, cfg.Retry, chain.NewCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown))
+	var ch chain.ChainClient = rc
+	if cfg.RecordDir != "" {
+		path := filepath.Join(cfg.RecordDir, fmt.Sprintf("chain-%d.jsonl", cfg.ChainID))
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("recording chain %d RPC calls to %s", cfg.ChainID, path)
+		ch = chain.NewRecorder(ch, f)
+	}
+	if cfg.CacheSize > 0 {
+		ch = chain.NewCaching(ch, cfg.CacheSize)
+	}
+	// head subscriptions need a websocket endpoint: a dedicated WSUrl, or RPCUrl itself.
+	// A recording engine polls, so that every head it sees is in the fixture.
+	var heads chain.HeadSubscriber
+	switch {
+	case cfg.RecordDir != "":
+	case cfg.WSUrl != "":
+		ws, err := chain.New(cfg.WSUrl)
+		if err != nil {
+			log.Printf("failed to dial %s, polling only: %v", cfg.WSUrl, err)
+		} else {
+			heads = ws
+		}
+	case strings.HasPrefix(cfg.RPCUrl, "ws"):
+		heads = rc
+	}
+	return newChainProcessor(cfg, st, ch, heads), nil
+}
+
+// dialChain connects to Config.RPCUrl, or to every Config.RPCUrls endpoint behind a
+// failover MultiClient when more than one provider is configured.
+func dialChain(cfg *Config) (chain.ChainClient, error) {
+	dial := func(u string) (chain.ChainClient, error) { return chain.New(u) }
+	if cfg.Bitcoin {
+		dial = func(u string) (chain.ChainClient, error) { return chain.NewBitcoin(u) }
+	}
+	if len(cfg.RPCUrls) == 0 {
+		return dial(cfg.RPCUrl)
+	}
+	providers := make([]chain.Provider, 0, len(cfg.RPCUrls))
+	for _, u := range cfg.RPCUrls {
+		c, err := dial(u)
+		if err != nil {
+			return nil, err
+		}
+		// name providers by host so API keys in the URL path never reach the logs
+		name := u
+		if pu, err := url.Parse(u); err == nil && pu.Host != "" {
+			name = pu.Host
+		}
+		providers = append(providers, chain.Provider{Name: name, Client: c})
+	}
+	m, err := chain.NewMulti(providers, cfg.MaxProviderLag, cfg.Quorum)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
 }
 
-// NewServiceWithStore creates a Service with an injected store and chain client (testable).
+// wrapL2 makes the safe and finalized heads of an L2 client follow L1 batch inclusion,
+// read from the op-node at Config.RollupNodeURL when one is configured.
+func wrapL2(cfg *Config, inner chain.ChainClient) (chain.ChainClient, error) {
+	var oracle chain.BatchOracle
+	if cfg.RollupNodeURL != "" {
+		op, err := chain.NewOPNodeOracle(cfg.RollupNodeURL)
+		if err != nil {
+			return nil, err
+		}
+		oracle = op
+	}
+	return chain.NewL2(inner, oracle), nil
+}
+
+// NewServiceWithStore creates a Service for the single chain Config.ChainID with an
+// injected store and chain client (testable). The client's head subscription is used when
+// it offers one.
 func NewServiceWithStore(cfg *Config, s *store.Store, ch chain.ChainClient) *Service {
-	return &Service{cfg: cfg, db: nil, store: s, chain: ch}
+	reg := chain.NewRegistry()
+	_ = reg.Register(cfg.ChainID, ch)
+	return NewServiceWithRegistry(cfg, s, reg)
+}
+
+// NewServiceWithRegistry creates a Service for every configured chain that has a client
+// in reg. Chains without a client are skipped.
+func NewServiceWithRegistry(cfg *Config, s *store.Store, reg *chain.Registry) *Service {
+	svc := &Service{cfg: cfg, store: s, chains: reg}
+	for _, ccfg := range cfg.chainConfigs() {
+		ch, ok := reg.Client(ccfg.ChainID)
+		if !ok {
+			log.Printf("no client registered for chain %d, skipping it", ccfg.ChainID)
+			continue
+		}
+		heads, _ := ch.(chain.HeadSubscriber)
+		svc.procs = append(svc.procs, newChainProcessor(ccfg, s, ch, heads))
+	}
+	return svc
 }
 
-// Run starts a tiny HTTP UI and a background poll loop.
+// Run starts a tiny HTTP UI, the admin API on Config.AdminAddr, and the processing loop.
 func (s *Service) Run(ctx context.Context) error {
+	srv := &http.Server{Addr: ":8080", Handler: s.handler()}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("http server error: %v", err)
+		}
+	}()
+	if s.cfg.AdminAddr != "" {
+		admin := &http.Server{Addr: s.cfg.AdminAddr, Handler: s.adminHandler()}
+		go func() {
+			if err := admin.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("admin server error: %v", err)
+			}
+		}()
+	}
+
+	// each chain follows its own heads; a slow or failing chain does not hold up the others
+	var wg sync.WaitGroup
+	for _, p := range s.procs {
+		wg.Add(1)
+		go func(p *chainProcessor) {
+			defer wg.Done()
+			_ = p.loop(ctx, p.tick)
+		}(p)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// handler serves the public deposits page.
+func (s *Service) handler() http.Handler {
 	mux := http.NewServeMux()
 	tmpl := template.Must(template.New("ui").Parse(indexHTML))
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
 		deposits, _ := s.store.ListDeposits(r.Context())
 		_ = tmpl.Execute(w, deposits)
 	})
-	srv := &http.Server{Addr: ":8080", Handler: mux}
-	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("http server error: %v", err)
-		}
-	}()
+	return mux
+}
+
+// adminHandler serves the account and forwarder flush APIs. It is only mounted on
+// Config.AdminAddr, never on the public listener.
+func (s *Service) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/accounts", s.handleAllocate)
+	mux.HandleFunc("/flushes", s.handleFlushes)
+	return mux
+}
 
-	ticker := time.NewTicker(s.cfg.PollInterval)
+// loop runs tick once per new head while a head subscription is live, and on the
+// Config.PollInterval ticker otherwise. A dropped subscription falls back to polling and
+// is retried every Config.ResubscribeInterval. A subscription that stays open but
+// delivers no head for Config.HeadTimeout is treated as stalled: the loop runs a cycle
+// and replaces it.
+func (p *chainProcessor) loop(ctx context.Context, tick func(context.Context)) error {
+	ticker := time.NewTicker(p.cfg.PollInterval)
 	defer ticker.Stop()
+	resubscribe := time.NewTicker(p.cfg.ResubscribeInterval)
+	defer resubscribe.Stop()
+	var liveness *time.Timer
+	if p.cfg.HeadTimeout > 0 {
+		liveness = time.NewTimer(p.cfg.HeadTimeout)
+		defer liveness.Stop()
+	}
+
+	heads := make(chan chain.Header, 16)
+	sub := p.subscribe(ctx, heads)
+	defer func() {
+		if sub != nil {
+			sub.Unsubscribe()
+		}
+	}()
 
 	for {
+		var poll, stalled <-chan time.Time
+		var subErr <-chan error
+		if sub == nil {
+			poll = ticker.C
+		} else {
+			subErr = sub.Err()
+			if liveness != nil {
+				stalled = liveness.C
+			}
+		}
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-ticker.C:
-			if err := s.ProcessOnce(ctx); err != nil {
-				log.Printf("process once error: %v", err)
+		case <-poll:
+			tick(ctx)
+		case <-heads:
+			// several heads may have queued while the last cycle ran; one cycle covers them
+			for len(heads) > 0 {
+				<-heads
+			}
+			tick(ctx)
+			resetTimer(liveness, p.cfg.HeadTimeout)
+		case <-stalled:
+			log.Printf("chain %d head subscription silent for %s, resubscribing", p.cfg.ChainID, p.cfg.HeadTimeout)
+			sub.Unsubscribe()
+			tick(ctx)
+			sub = p.subscribe(ctx, heads)
+			resetTimer(liveness, p.cfg.HeadTimeout)
+		case err := <-subErr:
+			log.Printf("chain %d head subscription dropped, falling back to polling: %v", p.cfg.ChainID, err)
+			sub.Unsubscribe()
+			sub = nil
+		case <-resubscribe.C:
+			if sub == nil {
+				if sub = p.subscribe(ctx, heads); sub != nil {
+					resetTimer(liveness, p.cfg.HeadTimeout)
+				}
 			}
 		}
 	}
 }
 
-// ProcessOnce processes pending deposits: consults chain (if provided), updates DB and credits idempotently.
-func (s *Service) ProcessOnce(ctx context.Context) error {
-	deposits, err := s.store.GetPendingDeposits(ctx)
+// resetTimer restarts t to fire after d, discarding an expiry not yet received. A nil t
+// is left alone.
+func resetTimer(t *time.Timer, d time.Duration) {
+	if t == nil {
+		return
+	}
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
+
+// subscribe opens a head subscription, returning nil when none is available.
+func (p *chainProcessor) subscribe(ctx context.Context, heads chan<- chain.Header) chain.Subscription {
+	if p.heads == nil {
+		return nil
+	}
+	sub, err := p.heads.SubscribeNewHeads(ctx, heads)
 	if err != nil {
-		return err
+		log.Printf("chain %d head subscription failed, polling every %s: %v", p.cfg.ChainID, p.cfg.PollInterval, err)
+		return nil
 	}
-	for _, d := range deposits {
-		if s.chain == nil {
-			if d.Confirmations >= s.cfg.Confirmations {
-				if err := s.store.CreditIfNotCredited(ctx, d); err != nil {
-					log.Printf("failed to credit deposit %s: %v", d.TxHash, err)
-				}
-			}
+	return sub
+}
+
+// tick runs one full cycle for the chain: reorg check, block scan, then deposit processing.
+// The head is read once and all three work against it, so they agree on the chain's state
+// and a cycle costs one eth_blockNumber.
+func (p *chainProcessor) tick(ctx context.Context) {
+	head, err := p.head(ctx)
+	if err != nil {
+		log.Printf("chain %d head error: %v", p.cfg.ChainID, err)
+		return
+	}
+	if err := p.checkReorgs(ctx, head); err != nil {
+		log.Printf("chain %d reorg check error: %v", p.cfg.ChainID, err)
+	}
+	if err := p.scanner.scanTo(ctx, head); err != nil {
+		log.Printf("chain %d scan error: %v", p.cfg.ChainID, err)
+	}
+	if err := p.processOnce(ctx, head); err != nil {
+		log.Printf("chain %d process once error: %v", p.cfg.ChainID, err)
+	}
+}
+
+// head reads the chain's current block number; it is 0 when there is no chain client.
+func (p *chainProcessor) head(ctx context.Context) (uint64, error) {
+	if p.chain == nil {
+		return 0, nil
+	}
+	return p.chain.BlockNumber(ctx)
+}
+
+// CheckReorgs runs the reorg check on every chain.
+func (s *Service) CheckReorgs(ctx context.Context) error {
+	var errs []error
+	for _, p := range s.procs {
+		if _, ok := p.chain.(chain.HeaderSource); !ok {
 			continue
 		}
+		head, err := p.head(ctx)
+		if err == nil {
+			err = p.checkReorgs(ctx, head)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("chain %d: %w", p.cfg.ChainID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
 
-		txBlock, conf, blockHash, found, reverted, err := s.chain.ConfirmationsFromTxHash(ctx, d.TxHash)
+// ProcessOnce processes the pending deposits of every chain, each with its own
+// confirmation policy. An error on one chain does not stop the others.
+func (s *Service) ProcessOnce(ctx context.Context) error {
+	var errs []error
+	for _, p := range s.procs {
+		head, err := p.head(ctx)
+		if err == nil {
+			err = p.processOnce(ctx, head)
+		}
 		if err != nil {
-			log.Printf("chain error: %v", err)
-			continue
+			errs = append(errs, fmt.Errorf("chain %d: %w", p.cfg.ChainID, err))
 		}
+	}
+	return errors.Join(errs...)
+}
 
-		if !found {
-			if err := s.store.MarkDepositReorged(ctx, d.ID); err != nil {
-				log.Printf("failed to mark reorged for %s: %v", d.TxHash, err)
+// checkReorgs advances the header tracker to head. When it reports orphaned blocks, the
+// reorg is recorded with its depth and every pending deposit in the orphaned range is
+// marked reorged at once.
+func (p *chainProcessor) checkReorgs(ctx context.Context, head uint64) error {
+	src, ok := p.chain.(chain.HeaderSource)
+	if !ok {
+		return nil
+	}
+	r, err := p.tracker.Update(ctx, src, head)
+	if errors.Is(err, chain.ErrUnsupported) {
+		return nil
+	}
+	if r != nil {
+		if inv, ok := p.chain.(chain.BlockInvalidator); ok {
+			for _, h := range r.Orphaned {
+				inv.InvalidateBlock(h.Hash)
 			}
-			continue
 		}
-
-		// compare nullable block hashes when available
-		var dBlockHash string
-		if d.BlockHash.Valid {
-			dBlockHash = d.BlockHash.String
+		ids, rerr := p.store.RecordReorg(ctx, models.Reorg{
+			ChainID:          p.cfg.ChainID,
+			FromBlock:        r.From,
+			ToBlock:          r.To,
+			Depth:            r.Depth,
+			OrphanedHeadHash: r.Orphaned[len(r.Orphaned)-1].Hash,
+		})
+		if rerr != nil {
+			return rerr
 		}
-		if dBlockHash != "" && blockHash != "" && dBlockHash != blockHash {
-			if err := s.store.MarkDepositReorged(ctx, d.ID); err != nil {
-				log.Printf("failed to mark reorged for %s: %v", d.TxHash, err)
+		log.Printf("chain %d reorg of depth %d detected (blocks %d-%d), %d deposits marked reorged", p.cfg.ChainID, r.Depth, r.From, r.To, len(ids))
+	}
+	return err
+}
+
+// processOnce processes the chain's pending deposits against head: consults the chain (if
+// provided), updates DB and credits idempotently. Credited deposits still in their watch window, and
+// recently reorged deposits, are re-checked in the same pass. Deposits are handled on
+// Config.Workers goroutines, one address at a time per worker.
+func (p *chainProcessor) processOnce(ctx context.Context, head uint64) error {
+	deposits, err := p.store.GetUnsettledDeposits(ctx, p.cfg.ChainID, p.watchUntil(), p.reorgedSince())
+	if err != nil {
+		return err
+	}
+	if p.chain == nil {
+		return p.forEachDeposit(ctx, deposits, func(d models.Deposit) {
+			if d.Status == models.StatusPending && d.Confirmations >= p.rule(d).confirmations {
+				if err := p.store.CreditIfNotCredited(ctx, d, nil); err != nil {
+					log.Printf("failed to credit deposit %s: %v", d.TxHash, err)
+				}
 			}
-			continue
+		})
+	}
+
+	statuses, err := p.txStatuses(ctx, deposits, head)
+	if err != nil {
+		return err
+	}
+	tagged := p.taggedHeads(ctx, deposits)
+	return p.forEachDeposit(ctx, deposits, func(d models.Deposit) {
+		st, ok := statuses[p.txKey(d)]
+		if !ok {
+			// lookup failed this cycle; leave the deposit untouched
+			return
 		}
+		switch d.Status {
+		case models.StatusCredited:
+			p.watchCredit(ctx, d, st)
+		case models.StatusReorged:
+			p.recheckReorged(ctx, d, st)
+		default:
+			p.settleDeposit(ctx, d, st, tagged)
+		}
+	})
+}
 
-		// update tx info (txBlock is a uint64 from chain; store.UpdateDepositTxInfo accepts uint64)
-		if err := s.store.UpdateDepositTxInfo(ctx, d.ID, txBlock, blockHash); err != nil {
-			log.Printf("failed to update tx info for %s: %v", d.TxHash, err)
+// settleDeposit applies what the chain says about a deposit's transaction: reorged,
+// reverted, still confirming, or final enough to credit.
+func (p *chainProcessor) settleDeposit(ctx context.Context, d models.Deposit, st txStatus, tagged map[FinalityMode]*chain.Header) {
+	if !st.found {
+		if err := p.store.MarkDepositReorged(ctx, d.ID, "receipt missing"); err != nil {
+			log.Printf("failed to mark reorged for %s: %v", d.TxHash, err)
 		}
-		if err := s.store.UpdateDepositConfirmations(ctx, d.ID, conf); err != nil {
-			log.Printf("failed to update confirmations for %s: %v", d.TxHash, err)
+		return
+	}
+
+	// compare nullable block hashes when available
+	var dBlockHash string
+	if d.BlockHash.Valid {
+		dBlockHash = d.BlockHash.String
+	}
+	if dBlockHash != "" && st.blockHash != "" && dBlockHash != st.blockHash {
+		if err := p.store.MarkDepositReorged(ctx, d.ID, "block hash changed"); err != nil {
+			log.Printf("failed to mark reorged for %s: %v", d.TxHash, err)
 		}
-		if reverted {
-			if err := s.store.MarkDepositReorged(ctx, d.ID); err != nil {
-				log.Printf("failed to mark reorged for %s: %v", d.TxHash, err)
-			}
-			continue
+		return
+	}
+
+	// update tx info (txBlock is a uint64 from chain; store.UpdateDepositTxInfo accepts uint64)
+	if err := p.store.UpdateDepositTxInfo(ctx, d.ID, st.txBlock, st.blockHash); err != nil {
+		log.Printf("failed to update tx info for %s: %v", d.TxHash, err)
+	}
+	if err := p.store.UpdateDepositConfirmations(ctx, d.ID, st.confirmations); err != nil {
+		log.Printf("failed to update confirmations for %s: %v", d.TxHash, err)
+	}
+	if st.reverted {
+		if err := p.store.MarkDepositFailed(ctx, d.ID); err != nil {
+			log.Printf("failed to mark failed for %s: %v", d.TxHash, err)
 		}
-		if conf >= s.cfg.Confirmations {
-			if err := s.store.CreditIfNotCredited(ctx, d); err != nil {
-				log.Printf("failed to credit deposit %s: %v", d.TxHash, err)
+		return
+	}
+	if ev, final := p.finality(p.rule(d), st, tagged); final {
+		mismatch, err := p.verifyDeposit(ctx, d, st)
+		switch {
+		case errors.Is(err, chain.ErrUnsupported) && p.cfg.CreditUnverified:
+			log.Printf("chain %d cannot verify deposit %s on-chain, crediting it unverified", p.cfg.ChainID, d.TxHash)
+		case errors.Is(err, chain.ErrUnsupported):
+			// fail closed: an unverifiable row must not mint balance
+			log.Printf("chain %d cannot verify deposit %s on-chain, holding it pending (CreditUnverified is off)", p.cfg.ChainID, d.TxHash)
+			return
+		case err != nil:
+			log.Printf("failed to verify deposit %s: %v", d.TxHash, err)
+			return
+		case mismatch != nil:
+			log.Printf("deposit %s does not match the chain (%s), not crediting", d.TxHash, mismatch.Reason)
+			if err := p.store.MarkDepositMismatch(ctx, d.ID, *mismatch); err != nil {
+				log.Printf("failed to mark mismatch for %s: %v", d.TxHash, err)
 			}
+			return
+		}
+		if err := p.store.CreditIfNotCredited(ctx, d, ev); err != nil {
+			log.Printf("failed to credit deposit %s: %v", d.TxHash, err)
 		}
 	}
-	return nil
 }
 
 // Minimal index page used by the (optional) HTTP UI.
-const indexHTML = `<html><body><h1>Deposits</h1>{{range .}}<div>{{.ID}} {{.TxHash}} {{.Status}}</div>{{end}}</body></html>`
+const indexHTML = `<html><body><h1>Deposits</h1>{{range .}}<div>{{.ID}} {{.ChainID}} {{.TxHash}} {{.Status}}</div>{{end}}</body></html>`
@@ -2,15 +2,36 @@ package engine
 
 import (
 	"context"
+	"database/sql/driver"
+	"math/big"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/namtran/creditengine/internal/chain"
+	"github.com/namtran/creditengine/internal/models"
 	st "github.com/namtran/creditengine/internal/store"
 )
 
+// pendingQuery is the query for pending and watched deposits issued once per chain.
+var pendingQuery = regexp.QuoteMeta("SELECT id, chain_id, tx_hash, log_index, trace_index, address, token, amount, confirmations, tx_block, block_hash, status, received_at, needs_flush, recipient FROM deposits WHERE chain_id = $1 AND (status = 'pending' OR (status = 'credited' AND confirmations < $2) OR (status = 'reorged' AND reorged_at > $3)) ORDER BY id")
+
+func depositRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "chain_id", "tx_hash", "log_index", "trace_index", "address", "token", "amount", "confirmations", "tx_block", "block_hash", "status", "received_at", "needs_flush", "recipient"})
+}
+
+// expectTransition expects the store to lock deposit id in status from, move it to status
+// to and record the move in its status history.
+func expectTransition(mock sqlmock.Sqlmock, id int, from, to models.DepositStatus) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT status, chain_id, tx_block, block_hash FROM deposits WHERE id = $1 FOR UPDATE")).WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "chain_id", "tx_block", "block_hash"}).AddRow(string(from), 1, nil, nil))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET status = $1 WHERE id = $2")).WithArgs(string(to), id).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO deposit_status_history(deposit_id, from_status, to_status, reason, chain_id, tx_block, block_hash, created_at) VALUES($1, $2, $3, $4, $5, $6, $7, $8)")).
+		WithArgs(id, string(from), string(to), sqlmock.AnyArg(), 1, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
 func TestProcessOnce_CreditsWhenConfirmed(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -19,8 +40,8 @@ func TestProcessOnce_CreditsWhenConfirmed(t *testing.T) {
 	defer func() { _ = db.Close() }()
 
 	// pending deposit row
-	rows := sqlmock.NewRows([]string{"id", "tx_hash", "address", "amount", "confirmations", "tx_block", "block_hash", "status", "received_at"}).AddRow(1, "0xabc", "0xaddr", 1000, 11, 90, "0xhash", "pending", time.Now())
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, tx_hash, address, amount, confirmations, tx_block, block_hash, status, received_at FROM deposits WHERE status = 'pending'")).WillReturnRows(rows)
+	rows := depositRows().AddRow(1, 1, "0xabc", -1, 0, "0xaddr", nil, 1000, 11, 90, "0xhash", "pending", time.Now(), false, nil)
+	mock.ExpectQuery(pendingQuery).WillReturnRows(rows)
 
 	// Update tx info
 	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET tx_block = $1, block_hash = $2 WHERE id = $3")).WillReturnResult(sqlmock.NewResult(1, 1))
@@ -28,10 +49,10 @@ func TestProcessOnce_CreditsWhenConfirmed(t *testing.T) {
 	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET confirmations = $1 WHERE id = $2")).WillReturnResult(sqlmock.NewResult(1, 1))
 	// Begin credit transaction
 	mock.ExpectBegin()
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT status FROM deposits WHERE id = $1 FOR UPDATE")).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("pending"))
-	mock.ExpectExec(regexp.QuoteMeta("UPDATE accounts SET balance = balance + $1 WHERE address = $2")).WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET status = 'credited', credited_at = $1 WHERE id = $2")).WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audits(deposit_id, action, created_at) VALUES($1, $2, $3)")).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectTransition(mock, 1, models.StatusPending, models.StatusCredited)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE accounts SET balance = balance + $1 WHERE chain_id = $2 AND address = $3")).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET credited_at = $1 WHERE id = $2")).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audits(deposit_id, action, details, created_at) VALUES($1, $2, $3, $4)")).WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectCommit()
 
 	// Setup mock chain with confirmations >= 12
@@ -42,6 +63,7 @@ func TestProcessOnce_CreditsWhenConfirmed(t *testing.T) {
 		Hash     string
 		Reverted bool
 	}{Block: 90, Hash: "0xhash", Reverted: false}
+	mc.Blocks[90] = &chain.Block{Header: chain.Header{Number: 90, Hash: "0xhash"}, Txs: []chain.Tx{{Hash: "0xabc", To: "0xaddr", Value: big.NewInt(1000)}}}
 
 	cfg := DefaultConfig()
 	svc := NewServiceWithStore(cfg, st.New(db), mc)
@@ -63,12 +85,15 @@ func TestProcessOnce_MarksReorgWhenReceiptMissing(t *testing.T) {
 	defer func() { _ = db.Close() }()
 
 	// pending deposit row
-	rows := sqlmock.NewRows([]string{"id", "tx_hash", "address", "amount", "confirmations", "tx_block", "block_hash", "status", "received_at"}).AddRow(2, "0xdef", "0xaddr", 2000, 0, nil, nil, "pending", time.Now())
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, tx_hash, address, amount, confirmations, tx_block, block_hash, status, received_at FROM deposits WHERE status = 'pending'")).WillReturnRows(rows)
+	rows := depositRows().AddRow(2, 1, "0xdef", -1, 0, "0xaddr", nil, 2000, 0, nil, nil, "pending", time.Now(), false, nil)
+	mock.ExpectQuery(pendingQuery).WillReturnRows(rows)
 
 	// When receipt not found, mark reorged
-	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET status = 'reorged' WHERE id = $1")).WithArgs(2).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectBegin()
+	expectTransition(mock, 2, models.StatusPending, models.StatusReorged)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET reorged_at = $1 WHERE id = $2")).WithArgs(sqlmock.AnyArg(), 2).WillReturnResult(sqlmock.NewResult(0, 1))
 	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audits(deposit_id, action, created_at) VALUES($1, $2, $3)")).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	mc := chain.NewMock()
 	mc.Block = 100
@@ -85,3 +110,371 @@ func TestProcessOnce_MarksReorgWhenReceiptMissing(t *testing.T) {
 		t.Fatalf("unmet expectations: %v", err)
 	}
 }
+
+func TestProcessOnce_MarksRevertedDepositFailed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	mock.ExpectQuery(pendingQuery).WillReturnRows(depositRows().AddRow(3, 1, "0xrev", -1, 0, "0xaddr", nil, 1000, 0, nil, nil, "pending", time.Now(), false, nil))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET tx_block = $1, block_hash = $2 WHERE id = $3")).WithArgs(95, "0xb95", 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET confirmations = $1 WHERE id = $2")).WithArgs(6, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	// a reverted transaction fails the deposit for good rather than reorging it
+	mock.ExpectBegin()
+	expectTransition(mock, 3, models.StatusPending, models.StatusFailed)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audits(deposit_id, action, created_at) VALUES($1, $2, $3)")).WithArgs(3, "failed", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	mc := chain.NewMock()
+	mc.Block = 100
+	mc.TxInfo["0xrev"] = struct {
+		Block    uint64
+		Hash     string
+		Reverted bool
+	}{Block: 95, Hash: "0xb95", Reverted: true}
+
+	svc := NewServiceWithStore(DefaultConfig(), st.New(db), mc)
+	if err := svc.ProcessOnce(context.Background()); err != nil {
+		t.Fatalf("ProcessOnce error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestCheckReorgs_MarksOrphanedRange(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	mc := chain.NewMock()
+	mc.Block = 101
+	mc.Blocks[100] = &chain.Block{Header: chain.Header{Number: 100, Hash: "0xa100", ParentHash: "0xa99"}}
+	mc.Blocks[101] = &chain.Block{Header: chain.Header{Number: 101, Hash: "0xa101", ParentHash: "0xa100"}}
+
+	svc := NewServiceWithStore(DefaultConfig(), st.New(db), mc)
+	// seed the tracker with 100 and 101
+	mc.Block = 100
+	if err := svc.CheckReorgs(context.Background()); err != nil {
+		t.Fatalf("CheckReorgs error: %v", err)
+	}
+	mc.Block = 101
+	if err := svc.CheckReorgs(context.Background()); err != nil {
+		t.Fatalf("CheckReorgs error: %v", err)
+	}
+
+	// block 101 is replaced and the chain grows to 102
+	mc.Block = 102
+	mc.Blocks[101] = &chain.Block{Header: chain.Header{Number: 101, Hash: "0xb101", ParentHash: "0xa100"}}
+	mc.Blocks[102] = &chain.Block{Header: chain.Header{Number: 102, Hash: "0xb102", ParentHash: "0xb101"}}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO reorgs(chain_id, from_block, to_block, depth, orphaned_head_hash, detected_at) VALUES($1, $2, $3, $4, $5, $6)")).
+		WithArgs(1, 101, 101, 1, "0xa101", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE deposits SET status = 'reorged', reorged_at = $4 WHERE chain_id = $1 AND status = 'pending' AND tx_block BETWEEN $2 AND $3 RETURNING id, tx_block, block_hash")).
+		WithArgs(1, 101, 101, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id", "tx_block", "block_hash"}).AddRow(7, 101, "0xa101").AddRow(8, 101, "0xa101"))
+	for _, id := range []int{7, 8} {
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO deposit_status_history(deposit_id, from_status, to_status, reason, chain_id, tx_block, block_hash, created_at) VALUES($1, $2, $3, $4, $5, $6, $7, $8)")).
+			WithArgs(id, "pending", "reorged", "reorg of depth 1 orphaned blocks 101-101", 1, 101, "0xa101", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audits(deposit_id, action, created_at) VALUES($1, $2, $3)")).WithArgs(id, "reorged", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	mock.ExpectCommit()
+
+	if err := svc.CheckReorgs(context.Background()); err != nil {
+		t.Fatalf("CheckReorgs error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+// failingChain answers every receipt lookup with err. It embeds the bare interface, so
+// the engine takes the per-transaction (non-batched) path.
+type failingChain struct {
+	chain.ChainClient
+	err error
+}
+
+func (f failingChain) ConfirmationsFromTxHash(ctx context.Context, txHash string) (uint64, uint64, string, bool, bool, error) {
+	return 0, 0, "", false, false, f.err
+}
+
+func TestProcessOnce_ChainErrorsLeaveDepositsPending(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		err     error
+		wantErr bool
+	}{
+		{"transient", &chain.TransientError{Err: context.DeadlineExceeded}, false},
+		{"circuit open", chain.ErrCircuitOpen, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("sqlmock: %v", err)
+			}
+			defer func() { _ = db.Close() }()
+
+			rows := depositRows().
+				AddRow(3, 1, "0x111", -1, 0, "0xaddr", nil, 1000, 0, nil, nil, "pending", time.Now(), false, nil).
+				AddRow(4, 1, "0x222", -1, 0, "0xaddr", nil, 1000, 0, nil, nil, "pending", time.Now(), false, nil)
+			mock.ExpectQuery(pendingQuery).WillReturnRows(rows)
+			// no further statements: nothing may be marked reorged or credited
+
+			svc := NewServiceWithStore(DefaultConfig(), st.New(db), failingChain{ChainClient: chain.NewMock(), err: tc.err})
+			err = svc.ProcessOnce(context.Background())
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ProcessOnce error = %v, wantErr %v", err, tc.wantErr)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("unmet expectations: %v", err)
+			}
+		})
+	}
+}
+
+// countingChain counts head and per-transaction receipt lookups.
+type countingChain struct {
+	*chain.MockClient
+	heads, lookups int
+}
+
+func (c *countingChain) BlockNumber(ctx context.Context) (uint64, error) {
+	c.heads++
+	return c.MockClient.BlockNumber(ctx)
+}
+
+func (c *countingChain) ConfirmationsFromTxHash(ctx context.Context, txHash string) (uint64, uint64, string, bool, bool, error) {
+	c.lookups++
+	return c.MockClient.ConfirmationsFromTxHash(ctx, txHash)
+}
+
+func TestProcessOnce_BatchesReceiptsAndReadsHeadOnce(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	// a multisend (two deposits, one tx) and a second tx, none of them mined any more
+	rows := depositRows().
+		AddRow(5, 1, "0xmulti", 0, 0, "0xa1", "0xusdc", 10, 0, nil, nil, "pending", time.Now(), false, nil).
+		AddRow(6, 1, "0xmulti", 1, 0, "0xa2", "0xusdc", 20, 0, nil, nil, "pending", time.Now(), false, nil).
+		AddRow(7, 1, "0xsolo", -1, 0, "0xa1", nil, 30, 0, nil, nil, "pending", time.Now(), false, nil)
+	mock.ExpectQuery(pendingQuery).WillReturnRows(rows)
+	// one worker handles 0xa1's deposits (5, 7) before 0xa2's
+	for _, id := range []int{5, 7, 6} {
+		mock.ExpectBegin()
+		expectTransition(mock, id, models.StatusPending, models.StatusReorged)
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET reorged_at = $1 WHERE id = $2")).WithArgs(sqlmock.AnyArg(), id).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audits(deposit_id, action, created_at) VALUES($1, $2, $3)")).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+	}
+
+	cc := &countingChain{MockClient: chain.NewMock()}
+	cc.Block = 100
+	cfg := DefaultConfig()
+	cfg.Workers = 1 // the expectations above are in a single worker's order
+	svc := NewServiceWithStore(cfg, st.New(db), cc)
+	if err := svc.ProcessOnce(context.Background()); err != nil {
+		t.Fatalf("ProcessOnce error: %v", err)
+	}
+	if cc.heads != 1 || cc.lookups != 0 {
+		t.Fatalf("expected one head read and no per-tx lookups, got %d and %d", cc.heads, cc.lookups)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+// A full cycle reads the head once and hands it to the reorg check, the scanner and the
+// receipt lookups.
+func TestTick_ReadsHeadOnce(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	cc := &countingChain{MockClient: chain.NewMock()}
+	cc.Block = 110
+	cc.Blocks[110] = &chain.Block{Header: chain.Header{Number: 110, Hash: "0xb110"}}
+	cc.TxInfo["0xabc"] = struct {
+		Block    uint64
+		Hash     string
+		Reverted bool
+	}{Block: 100, Hash: "0xb100"}
+
+	// the scanner is already past the head, so it only loads its checkpoint
+	expectCheckpoint(mock, nil)
+	mock.ExpectQuery(pendingQuery).WillReturnRows(depositRows().AddRow(1, 1, "0xabc", -1, 0, "0xaddr", nil, 1000, 0, 100, "0xb100", "pending", time.Now(), false, nil))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET tx_block = $1, block_hash = $2 WHERE id = $3")).WithArgs(100, "0xb100", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET confirmations = $1 WHERE id = $2")).WithArgs(11, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	cfg := DefaultConfig()
+	cfg.ScanStartBlock = 1000
+	svc := NewServiceWithStore(cfg, st.New(db), cc)
+	svc.procs[0].tick(context.Background())
+
+	if cc.heads != 1 || cc.lookups != 0 {
+		t.Fatalf("expected one head read and no per-tx lookups, got %d and %d", cc.heads, cc.lookups)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+// On Bitcoin each deposit is looked up by its outpoint, so an output the transaction does
+// not have is not found even though the txid is.
+func TestTxStatuses_LooksUpBitcoinOutpoints(t *testing.T) {
+	mc := chain.NewMock()
+	mc.Block = 110
+	mc.TxInfo[chain.Outpoint("abc", 1)] = struct {
+		Block    uint64
+		Hash     string
+		Reverted bool
+	}{Block: 105, Hash: "0xb105"}
+	cfg := DefaultConfig()
+	cfg.Bitcoin = true
+	p := newChainProcessor(cfg, nil, mc, nil)
+
+	deps := []models.Deposit{{ID: 1, TxHash: "abc", LogIndex: 1}, {ID: 2, TxHash: "abc", LogIndex: 2}}
+	res, err := p.txStatuses(context.Background(), deps, 110)
+	if err != nil {
+		t.Fatalf("txStatuses error: %v", err)
+	}
+	if st := res[p.txKey(deps[0])]; !st.found || st.confirmations != 6 {
+		t.Fatalf("expected abc:1 found with 6 confirmations, got %+v", st)
+	}
+	if st, ok := res[p.txKey(deps[1])]; !ok || st.found {
+		t.Fatalf("expected abc:2 looked up and not found, got %+v (present %v)", st, ok)
+	}
+}
+
+// jsonContains matches a JSON argument containing every given fragment.
+type jsonContains []string
+
+func (j jsonContains) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	if !ok {
+		return false
+	}
+	for _, frag := range j {
+		if !strings.Contains(s, frag) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestProcessOnce_FinalizedModeWaitsForFinalizedHead(t *testing.T) {
+	newRows := func() *sqlmock.Rows {
+		return depositRows().
+			AddRow(1, 1, "0xabc", -1, 0, "0xaddr", nil, 1000, 13, 90, "0xhash", "pending", time.Now(), false, nil)
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	mc := chain.NewMock()
+	mc.Block = 102
+	mc.TxInfo["0xabc"] = struct {
+		Block    uint64
+		Hash     string
+		Reverted bool
+	}{Block: 90, Hash: "0xhash"}
+	mc.Blocks[90] = &chain.Block{Header: chain.Header{Number: 90, Hash: "0xhash"}, Txs: []chain.Tx{{Hash: "0xabc", To: "0xaddr", Value: big.NewInt(1000)}}}
+	cfg := DefaultConfig()
+	cfg.FinalityMode = FinalityFinalized
+	svc := NewServiceWithStore(cfg, st.New(db), mc)
+
+	// 13 confirmations but the finalized head is still below the deposit: no credit
+	mc.Tags[chain.TagFinalized] = 85
+	mock.ExpectQuery(pendingQuery).WillReturnRows(newRows())
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET tx_block = $1, block_hash = $2 WHERE id = $3")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET confirmations = $1 WHERE id = $2")).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := svc.ProcessOnce(context.Background()); err != nil {
+		t.Fatalf("ProcessOnce error: %v", err)
+	}
+
+	// once finalized past the deposit's block it is credited with the evidence audited
+	mc.Tags[chain.TagFinalized] = 95
+	mock.ExpectQuery(pendingQuery).WillReturnRows(newRows())
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET tx_block = $1, block_hash = $2 WHERE id = $3")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET confirmations = $1 WHERE id = $2")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	expectTransition(mock, 1, models.StatusPending, models.StatusCredited)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE accounts SET balance = balance + $1 WHERE chain_id = $2 AND address = $3")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET credited_at = $1 WHERE id = $2")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audits(deposit_id, action, details, created_at) VALUES($1, $2, $3, $4)")).
+		WithArgs(1, "credited", jsonContains{`"mode":"finalized"`, `"tagged_block":95`}, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	if err := svc.ProcessOnce(context.Background()); err != nil {
+		t.Fatalf("ProcessOnce error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestProcessOnce_AppliesEachChainsConfirmationPolicy(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	// the same tx hash on two chains is two different deposits, each with 20 confirmations
+	newChain := func() *chain.MockClient {
+		mc := chain.NewMock()
+		mc.Block = 119
+		mc.TxInfo["0xabc"] = struct {
+			Block    uint64
+			Hash     string
+			Reverted bool
+		}{Block: 100, Hash: "0xhash"}
+		mc.Blocks[100] = &chain.Block{Header: chain.Header{Number: 100, Hash: "0xhash"}, Txs: []chain.Tx{{Hash: "0xabc", To: "0xaddr", Value: big.NewInt(1000)}}}
+		return mc
+	}
+	reg := chain.NewRegistry()
+	_ = reg.Register(1, newChain())
+	_ = reg.Register(137, newChain())
+
+	cfg := DefaultConfig()
+	cfg.Chains = []ChainConfig{{ChainID: 137, Confirmations: 64}}
+	svc := NewServiceWithRegistry(cfg, st.New(db), reg)
+
+	// Ethereum (12 confirmations required): credited
+	mock.ExpectQuery(pendingQuery).WithArgs(1, 76, sqlmock.AnyArg()).WillReturnRows(depositRows().AddRow(1, 1, "0xabc", -1, 0, "0xaddr", nil, 1000, 0, 100, "0xhash", "pending", time.Now(), false, nil))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET tx_block = $1, block_hash = $2 WHERE id = $3")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET confirmations = $1 WHERE id = $2")).WithArgs(20, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	expectTransition(mock, 1, models.StatusPending, models.StatusCredited)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE accounts SET balance = balance + $1 WHERE chain_id = $2 AND address = $3")).WithArgs("1000", 1, "0xaddr").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET credited_at = $1 WHERE id = $2")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audits(deposit_id, action, details, created_at) VALUES($1, $2, $3, $4)")).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	// Polygon (64 confirmations required): still confirming
+	mock.ExpectQuery(pendingQuery).WithArgs(137, 128, sqlmock.AnyArg()).WillReturnRows(depositRows().AddRow(2, 137, "0xabc", -1, 0, "0xaddr", nil, 1000, 0, 100, "0xhash", "pending", time.Now(), false, nil))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET tx_block = $1, block_hash = $2 WHERE id = $3")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET confirmations = $1 WHERE id = $2")).WithArgs(20, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := svc.ProcessOnce(context.Background()); err != nil {
+		t.Fatalf("ProcessOnce error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
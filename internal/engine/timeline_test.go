@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"context"
+	"math/big"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/namtran/creditengine/internal/chain"
+	"github.com/namtran/creditengine/internal/models"
+	st "github.com/namtran/creditengine/internal/store"
+)
+
+// TestTimeline_ReorgedOutDeposit plays out "included at 90, reorged out at 95, re-included
+// at 97": the deposit confirms, the fork orphans its block, the reorg marks it reorged and
+// drops the cached receipt, and once the transaction is mined again the deposit is back
+// to pending at its new block.
+func TestTimeline_ReorgedOutDeposit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	sim := chain.NewSimulator()
+	sim.MineTo(89)
+	cached := chain.NewCaching(sim, 16)
+	svc := NewServiceWithStore(DefaultConfig(), st.New(db), cached)
+	ctx := context.Background()
+	if err := svc.CheckReorgs(ctx); err != nil {
+		t.Fatalf("CheckReorgs error: %v", err)
+	}
+
+	sim.Send(chain.SimTx{Tx: chain.Tx{Hash: "0xdep", To: "0xaddr", Value: big.NewInt(1000)}})
+	sim.Mine(1)
+	b90, _ := sim.HeaderByNumber(ctx, 90)
+	head := sim.MineTo(95)
+
+	// at 95 the deposit has 6 of 12 confirmations
+	mock.ExpectQuery(pendingQuery).WillReturnRows(depositRows().AddRow(1, 1, "0xdep", -1, 0, "0xaddr", nil, 1000, 0, 90, b90.Hash, "pending", time.Now(), false, nil))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET tx_block = $1, block_hash = $2 WHERE id = $3")).WithArgs(90, b90.Hash, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET confirmations = $1 WHERE id = $2")).WithArgs(6, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := svc.CheckReorgs(ctx); err != nil {
+		t.Fatalf("CheckReorgs error: %v", err)
+	}
+	if err := svc.ProcessOnce(ctx); err != nil {
+		t.Fatalf("ProcessOnce error: %v", err)
+	}
+
+	// a fork from 89 replaces 90-95 and the deposit is evicted
+	sim.Reorg(6)
+	sim.Drop("0xdep")
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO reorgs(chain_id, from_block, to_block, depth, orphaned_head_hash, detected_at) VALUES($1, $2, $3, $4, $5, $6)")).
+		WithArgs(1, 90, 95, 6, head.Hash, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE deposits SET status = 'reorged', reorged_at = $4 WHERE chain_id = $1 AND status = 'pending' AND tx_block BETWEEN $2 AND $3 RETURNING id, tx_block, block_hash")).
+		WithArgs(1, 90, 95, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id", "tx_block", "block_hash"}).AddRow(1, 90, b90.Hash))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO deposit_status_history(deposit_id, from_status, to_status, reason, chain_id, tx_block, block_hash, created_at) VALUES($1, $2, $3, $4, $5, $6, $7, $8)")).
+		WithArgs(1, "pending", "reorged", "reorg of depth 6 orphaned blocks 90-95", 1, 90, b90.Hash, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audits(deposit_id, action, created_at) VALUES($1, $2, $3)")).WithArgs(1, "reorged", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	if err := svc.CheckReorgs(ctx); err != nil {
+		t.Fatalf("CheckReorgs error: %v", err)
+	}
+
+	// re-included at 97, where the chain now reports it rather than the cached block 90
+	sim.Reinclude("0xdep")
+	sim.Mine(1)
+	if txBlock, _, _, found, _, _ := cached.ConfirmationsFromTxHash(ctx, "0xdep"); !found || txBlock != 97 {
+		t.Fatalf("expected the deposit re-included at 97, got %d (found %v)", txBlock, found)
+	}
+	b97, _ := sim.HeaderByNumber(ctx, 97)
+	mock.ExpectQuery(pendingQuery).WillReturnRows(depositRows().AddRow(1, 1, "0xdep", -1, 0, "0xaddr", nil, 1000, 6, 90, b90.Hash, "reorged", time.Now(), false, nil))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET tx_block = $1, block_hash = $2, confirmations = $3, reorged_at = NULL WHERE id = $4")).
+		WithArgs(97, b97.Hash, 1, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectTransition(mock, 1, models.StatusReorged, models.StatusPending)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audits(deposit_id, action, details, created_at) VALUES($1, $2, $3, $4)")).
+		WithArgs(1, "reincluded", jsonContains{`"block_hash":"` + b90.Hash + `"`, `"new_tx_block":97`}, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	if err := svc.ProcessOnce(ctx); err != nil {
+		t.Fatalf("ProcessOnce error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/namtran/creditengine/internal/chain"
+	"github.com/namtran/creditengine/internal/models"
+)
+
+// errBlockMoved is returned when the block a deposit's receipt pointed at is no longer
+// the one the chain serves; the deposit is checked again next cycle.
+var errBlockMoved = errors.New("block changed during verification")
+
+// onChainTransfer is the transfer the chain holds for a deposit.
+type onChainTransfer struct {
+	recipient string
+	token     string // "" for native value
+	value     *big.Int
+}
+
+// verifyDeposit checks a deposit row against the chain before it is credited: the
+// transaction (native), Transfer log (token) or traced call (internal) it was recorded
+// from must exist in the receipt's block and pay the row's amount of the row's asset to
+// the row's address, or to the forwarder recorded as the row's recipient. It
+// returns nil evidence when everything matches, and an error wrapping
+// chain.ErrUnsupported when the client cannot serve the lookup.
+func (p *chainProcessor) verifyDeposit(ctx context.Context, d models.Deposit, st txStatus) (*models.MismatchEvidence, error) {
+	found, err := p.lookupTransfer(ctx, d, st)
+	if err != nil {
+		return nil, err
+	}
+	ev := &models.MismatchEvidence{TxBlock: st.txBlock, BlockHash: st.blockHash}
+	if found == nil {
+		ev.Reason = "missing"
+		return ev, nil
+	}
+	ev.Recipient, ev.Token, ev.Amount = found.recipient, found.token, found.value.String()
+
+	switch {
+	case !strings.EqualFold(found.recipient, expectedRecipient(d)):
+		ev.Reason = "recipient"
+	case !strings.EqualFold(found.token, d.Token.String):
+		ev.Reason = "token"
+	case found.value.Cmp(d.Amount.Big()) != 0:
+		ev.Reason = "amount"
+	default:
+		return nil, nil
+	}
+	return ev, nil
+}
+
+// expectedRecipient is the address the chain should show as paid: the account itself, or
+// the forwarder the scanner recorded as the deposit's recipient.
+func expectedRecipient(d models.Deposit) string {
+	if d.Recipient.Valid {
+		return d.Recipient.String
+	}
+	return d.Address
+}
+
+// lookupTransfer fetches the on-chain transfer a deposit was recorded from, or nil if the
+// receipt's block holds none.
+func (p *chainProcessor) lookupTransfer(ctx context.Context, d models.Deposit, st txStatus) (*onChainTransfer, error) {
+	switch {
+	case d.TraceIndex > 0:
+		src, ok := p.chain.(chain.TraceSource)
+		if !ok {
+			return nil, chain.ErrUnsupported
+		}
+		transfers, err := src.InternalTransfers(ctx, st.txBlock, st.blockHash)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range transfers {
+			if strings.EqualFold(t.TxHash, d.TxHash) && t.Index == d.TraceIndex {
+				return &onChainTransfer{recipient: t.To, value: valueOrZero(t.Value)}, nil
+			}
+		}
+		return nil, nil
+
+	case d.LogIndex >= 0:
+		src, ok := p.chain.(chain.LogSource)
+		if !ok {
+			return nil, chain.ErrUnsupported
+		}
+		// ask for every allowlisted token, so a log paying a different one is reported
+		// as a token mismatch rather than as missing
+		tokens := append([]string{d.Token.String}, p.cfg.Tokens...)
+		logs, err := src.TransferLogs(ctx, st.txBlock, st.txBlock, tokens)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range logs {
+			if !strings.EqualFold(l.TxHash, d.TxHash) || int64(l.LogIndex) != d.LogIndex {
+				continue
+			}
+			if !strings.EqualFold(l.BlockHash, st.blockHash) {
+				return nil, errBlockMoved
+			}
+			return &onChainTransfer{recipient: l.To, token: l.Token, value: valueOrZero(l.Value)}, nil
+		}
+		return nil, nil
+
+	default:
+		src, ok := p.chain.(chain.BlockSource)
+		if !ok {
+			return nil, chain.ErrUnsupported
+		}
+		b, err := src.BlockByNumber(ctx, st.txBlock)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(b.Hash, st.blockHash) {
+			return nil, errBlockMoved
+		}
+		for _, tx := range b.Txs {
+			if strings.EqualFold(tx.Hash, d.TxHash) {
+				return &onChainTransfer{recipient: tx.To, value: valueOrZero(tx.Value)}, nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+func valueOrZero(v *big.Int) *big.Int {
+	if v == nil {
+		return new(big.Int)
+	}
+	return v
+}
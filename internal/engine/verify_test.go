@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"math/big"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/namtran/creditengine/internal/chain"
+	"github.com/namtran/creditengine/internal/models"
+	st "github.com/namtran/creditengine/internal/store"
+)
+
+func TestVerifyDeposit(t *testing.T) {
+	const owner = "0x00000000000000000000000000000000000000a1"
+	cfg := DefaultConfig()
+	cfg.Tokens = []string{"0xusdc", "0xdai"}
+	cfg.ForwarderFactory = "0xdeadbeef00000000000000000000000000000000"
+	cfg.ForwarderInitCodeHash = "0xbc36789e7a1e281436464229828f817d6612f7b477d66591ff96a9e064bcc98a"
+	fwd, err := chain.ForwarderAddress(cfg.ForwarderFactory, cfg.ForwarderInitCodeHash, owner)
+	if err != nil {
+		t.Fatalf("ForwarderAddress: %v", err)
+	}
+
+	mc := chain.NewMock()
+	mc.Blocks[90] = &chain.Block{Header: chain.Header{Number: 90, Hash: "0xb90"}, Txs: []chain.Tx{{Hash: "0xeth", To: "0x00000000000000000000000000000000000000A1", Value: big.NewInt(1000)}}}
+	mc.Logs = []chain.TransferLog{
+		{TxHash: "0xtok", LogIndex: 3, BlockNumber: 90, BlockHash: "0xb90", Token: "0xusdc", To: owner, Value: big.NewInt(50)},
+		{TxHash: "0xtok", LogIndex: 4, BlockNumber: 90, BlockHash: "0xb90", Token: "0xusdc", To: fwd, Value: big.NewInt(60)},
+	}
+	mc.Traces[90] = []chain.InternalTransfer{{TxHash: "0xcall", Index: 2, To: owner, Value: big.NewInt(7)}}
+	p := newChainProcessor(cfg, nil, mc, nil)
+	status := txStatus{txBlock: 90, blockHash: "0xb90", found: true}
+
+	usdc := sql.NullString{String: "0xusdc", Valid: true}
+	dai := sql.NullString{String: "0xdai", Valid: true}
+	viaFwd := sql.NullString{String: fwd, Valid: true}
+	tests := []struct {
+		name   string
+		d      models.Deposit
+		reason string // "" when the deposit matches
+	}{
+		{"native", models.Deposit{TxHash: "0xeth", LogIndex: -1, Address: owner, Amount: models.Int64Amount(1000)}, ""},
+		{"native amount", models.Deposit{TxHash: "0xeth", LogIndex: -1, Address: owner, Amount: models.Int64Amount(1001)}, "amount"},
+		{"native recipient", models.Deposit{TxHash: "0xeth", LogIndex: -1, Address: "0x00000000000000000000000000000000000000a2", Amount: models.Int64Amount(1000)}, "recipient"},
+		{"native missing", models.Deposit{TxHash: "0xnone", LogIndex: -1, Address: owner, Amount: models.Int64Amount(1000)}, "missing"},
+		{"token", models.Deposit{TxHash: "0xtok", LogIndex: 3, Address: owner, Token: usdc, Amount: models.Int64Amount(50)}, ""},
+		{"token asset", models.Deposit{TxHash: "0xtok", LogIndex: 3, Address: owner, Token: dai, Amount: models.Int64Amount(50)}, "token"},
+		{"token missing log", models.Deposit{TxHash: "0xtok", LogIndex: 5, Address: owner, Token: usdc, Amount: models.Int64Amount(50)}, "missing"},
+		{"token via forwarder", models.Deposit{TxHash: "0xtok", LogIndex: 4, Address: owner, Token: usdc, Amount: models.Int64Amount(60), NeedsFlush: true, Recipient: viaFwd}, ""},
+		// a sweep clearing needs_flush does not change who the chain paid
+		{"token via flushed forwarder", models.Deposit{TxHash: "0xtok", LogIndex: 4, Address: owner, Token: usdc, Amount: models.Int64Amount(60), Recipient: viaFwd}, ""},
+		{"token not via forwarder", models.Deposit{TxHash: "0xtok", LogIndex: 4, Address: owner, Token: usdc, Amount: models.Int64Amount(60)}, "recipient"},
+		{"internal", models.Deposit{TxHash: "0xcall", LogIndex: -1, TraceIndex: 2, Address: owner, Amount: models.Int64Amount(7)}, ""},
+		{"internal token", models.Deposit{TxHash: "0xcall", LogIndex: -1, TraceIndex: 2, Address: owner, Token: usdc, Amount: models.Int64Amount(7)}, "token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev, err := p.verifyDeposit(context.Background(), tt.d, status)
+			if err != nil {
+				t.Fatalf("verifyDeposit: %v", err)
+			}
+			reason := ""
+			if ev != nil {
+				reason = ev.Reason
+			}
+			if reason != tt.reason {
+				t.Fatalf("expected mismatch %q, got %q (%+v)", tt.reason, reason, ev)
+			}
+		})
+	}
+
+	// a changed forwarder config does not affect deposits already recorded
+	cfg.ForwarderInitCodeHash = "0x" + strings.Repeat("11", 32)
+	if ev, err := p.verifyDeposit(context.Background(), tests[7].d, status); err != nil || ev != nil {
+		t.Fatalf("expected the forwarder deposit to still match, got %+v, %v", ev, err)
+	}
+
+	// the block was replaced after the receipt was read: no verdict this cycle
+	if _, err := p.verifyDeposit(context.Background(), tests[0].d, txStatus{txBlock: 90, blockHash: "0xother", found: true}); err == nil {
+		t.Fatalf("expected an error for a block that moved")
+	}
+}
+
+func TestProcessOnce_MarksMismatchInsteadOfCrediting(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	// the row claims 1000 wei but the transaction carried 10
+	mock.ExpectQuery(pendingQuery).WillReturnRows(depositRows().AddRow(1, 1, "0xabc", -1, 0, "0xaddr", nil, 1000, 11, 90, "0xhash", "pending", time.Now(), false, nil))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET tx_block = $1, block_hash = $2 WHERE id = $3")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET confirmations = $1 WHERE id = $2")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	expectTransition(mock, 1, models.StatusPending, models.StatusMismatch)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audits(deposit_id, action, details, created_at) VALUES($1, $2, $3, $4)")).
+		WithArgs(1, "mismatch", jsonContains{`"reason":"amount"`, `"amount":"10"`, `"block_hash":"0xhash"`}, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	mc := chain.NewMock()
+	mc.Block = 102
+	mc.TxInfo["0xabc"] = struct {
+		Block    uint64
+		Hash     string
+		Reverted bool
+	}{Block: 90, Hash: "0xhash"}
+	mc.Blocks[90] = &chain.Block{Header: chain.Header{Number: 90, Hash: "0xhash"}, Txs: []chain.Tx{{Hash: "0xabc", To: "0xaddr", Value: big.NewInt(10)}}}
+
+	svc := NewServiceWithStore(DefaultConfig(), st.New(db), mc)
+	if err := svc.ProcessOnce(context.Background()); err != nil {
+		t.Fatalf("ProcessOnce error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+// receiptOnlyChain reports receipts but serves no blocks, logs or traces, like bitcoind.
+type receiptOnlyChain struct{}
+
+func (receiptOnlyChain) BlockNumber(ctx context.Context) (uint64, error) { return 102, nil }
+
+func (receiptOnlyChain) Confirmations(ctx context.Context, txBlockNumber uint64) (uint64, error) {
+	return 102 - txBlockNumber + 1, nil
+}
+
+func (receiptOnlyChain) ConfirmationsFromTxHash(ctx context.Context, txHash string) (uint64, uint64, string, bool, bool, error) {
+	return 90, 13, "0xhash", true, false, nil
+}
+
+func TestProcessOnce_HoldsUnverifiableDeposit(t *testing.T) {
+	for _, optIn := range []bool{false, true} {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("sqlmock: %v", err)
+		}
+
+		mock.ExpectQuery(pendingQuery).WillReturnRows(depositRows().AddRow(1, 1, "0xabc", -1, 0, "0xaddr", nil, 1000, 11, 90, "0xhash", "pending", time.Now(), false, nil))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET tx_block = $1, block_hash = $2 WHERE id = $3")).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET confirmations = $1 WHERE id = $2")).WillReturnResult(sqlmock.NewResult(0, 1))
+		// queued either way: without the opt-in the credit must not be attempted
+		mock.ExpectBegin()
+		expectTransition(mock, 1, models.StatusPending, models.StatusCredited)
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE accounts SET balance = balance + $1 WHERE chain_id = $2 AND address = $3")).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET credited_at = $1 WHERE id = $2")).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audits(deposit_id, action, details, created_at) VALUES($1, $2, $3, $4)")).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		cfg := DefaultConfig()
+		cfg.CreditUnverified = optIn
+		svc := NewServiceWithStore(cfg, st.New(db), receiptOnlyChain{})
+		if err := svc.ProcessOnce(context.Background()); err != nil {
+			t.Fatalf("ProcessOnce error: %v", err)
+		}
+		err = mock.ExpectationsWereMet()
+		if optIn && err != nil {
+			t.Fatalf("expected the opted-in chain to credit: %v", err)
+		}
+		if !optIn && err == nil {
+			t.Fatalf("expected the deposit to stay pending without CreditUnverified")
+		}
+		_ = db.Close()
+	}
+}
@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/namtran/creditengine/internal/chain"
+	"github.com/namtran/creditengine/internal/models"
+)
+
+// watchUntil is the confirmation count at which a credited deposit stops being watched,
+// or 0 when watching is disabled. The window starts at the highest confirmation count the
+// chain's policy requires.
+func (p *chainProcessor) watchUntil() uint64 {
+	if p.cfg.WatchWindow == 0 {
+		return 0
+	}
+	return p.cfg.maxConfirmations() + p.cfg.WatchWindow
+}
+
+// watchCredit re-checks a credited deposit within its watch window. A reorg deeper than
+// the confirmation policy shows up as a transaction that is gone, sits in a different
+// block or now reverts; the credit is then reversed and an alert raised. A transaction
+// that is gone must be corroborated first (see confirmMissing). Otherwise the receipt's
+// block is checked against the canonical header, since it may come from a cache, and
+// only the confirmation count advances, which eventually ends the watch.
+func (p *chainProcessor) watchCredit(ctx context.Context, d models.Deposit, st txStatus) {
+	ev := models.ReorgReversal{TxBlock: uint64(d.TxBlock.Int64), BlockHash: d.BlockHash.String, Confirmations: d.Confirmations}
+	switch {
+	case !st.found:
+		missing, err := p.confirmMissing(ctx, d)
+		if err != nil {
+			log.Printf("failed to corroborate missing credited deposit %s: %v", d.TxHash, err)
+			return
+		}
+		if !missing {
+			log.Printf("credited deposit %s not found but block %d is still canonical; not reversing", d.TxHash, d.TxBlock.Int64)
+			return
+		}
+		ev.Reason = "missing"
+	case d.BlockHash.Valid && st.blockHash != "" && d.BlockHash.String != st.blockHash:
+		ev.Reason = "moved"
+		ev.NewTxBlock, ev.NewBlockHash = st.txBlock, st.blockHash
+	case st.reverted:
+		ev.Reason = "reverted"
+	default:
+		// a cached receipt outlives a reorg the header tracker never reported (one deeper
+		// than its window, or one that happened while the engine was down)
+		if st.blockHash != "" {
+			canonical, err := p.isCanonical(ctx, st.txBlock, st.blockHash)
+			switch {
+			case errors.Is(err, chain.ErrUnsupported):
+			case err != nil:
+				log.Printf("failed to check block %d of credited deposit %s: %v", st.txBlock, d.TxHash, err)
+				return
+			case !canonical:
+				if inv, ok := p.chain.(chain.BlockInvalidator); ok {
+					inv.InvalidateBlock(st.blockHash)
+				}
+				log.Printf("receipt of credited deposit %s points at orphaned block %d; re-checking next cycle", d.TxHash, st.txBlock)
+				return
+			}
+		}
+		if err := p.store.UpdateDepositConfirmations(ctx, d.ID, st.confirmations); err != nil {
+			log.Printf("failed to update confirmations for %s: %v", d.TxHash, err)
+		}
+		return
+	}
+
+	if err := p.store.ReverseReorgedCredit(ctx, d.ID, ev); err != nil {
+		log.Printf("failed to reverse credit for %s: %v", d.TxHash, err)
+		return
+	}
+	err := p.alerter.Alert(ctx, Alert{
+		Kind:      "credit_reversed",
+		ChainID:   p.cfg.ChainID,
+		DepositID: d.ID,
+		TxHash:    d.TxHash,
+		Message:   fmt.Sprintf("credited deposit %s (%s to %s) was reorged out (%s) after %d confirmations; credit reversed", d.TxHash, d.Amount, d.Address, ev.Reason, d.Confirmations),
+		Details:   ev,
+		Time:      time.Now(),
+	})
+	if err != nil {
+		log.Printf("failed to send alert for %s: %v", d.TxHash, err)
+	}
+}
+
+// confirmMissing corroborates a lookup that found no transaction behind a credited
+// deposit: a single node answering not-found may be lagging, pruned or faulty. When the
+// chain serves headers, the block the deposit was credited in must no longer be canonical;
+// otherwise a second lookup must also come back empty.
+func (p *chainProcessor) confirmMissing(ctx context.Context, d models.Deposit) (bool, error) {
+	if d.TxBlock.Valid && d.BlockHash.Valid {
+		canonical, err := p.isCanonical(ctx, uint64(d.TxBlock.Int64), d.BlockHash.String)
+		if !errors.Is(err, chain.ErrUnsupported) {
+			return !canonical, err
+		}
+	}
+	_, _, _, found, _, err := p.chain.ConfirmationsFromTxHash(ctx, p.txKey(d))
+	if err != nil {
+		return false, err
+	}
+	return !found, nil
+}
+
+// isCanonical reports whether hash is the chain's canonical block at number. It returns
+// chain.ErrUnsupported when the client cannot serve headers.
+func (p *chainProcessor) isCanonical(ctx context.Context, number uint64, hash string) (bool, error) {
+	src, ok := p.chain.(chain.HeaderSource)
+	if !ok {
+		return false, chain.ErrUnsupported
+	}
+	h, err := src.HeaderByNumber(ctx, number)
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(h.Hash, hash), nil
+}
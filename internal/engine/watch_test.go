@@ -0,0 +1,193 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/namtran/creditengine/internal/chain"
+	"github.com/namtran/creditengine/internal/models"
+	st "github.com/namtran/creditengine/internal/store"
+)
+
+// recordingAlerter keeps the alerts it is sent.
+type recordingAlerter struct{ alerts []Alert }
+
+func (r *recordingAlerter) Alert(ctx context.Context, a Alert) error {
+	r.alerts = append(r.alerts, a)
+	return nil
+}
+
+func TestProcessOnce_ReversesCreditReorgedWithinWatchWindow(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	// credited at 13 confirmations; a reorg 20 blocks deep re-included the transaction
+	// at block 95 instead of 90
+	mc := chain.NewMock()
+	mc.Block = 110
+	mc.TxInfo["0xabc"] = struct {
+		Block    uint64
+		Hash     string
+		Reverted bool
+	}{Block: 95, Hash: "0xb95"}
+	// still-valid credits only have their confirmations advanced
+	mc.TxInfo["0xdef"] = struct {
+		Block    uint64
+		Hash     string
+		Reverted bool
+	}{Block: 92, Hash: "0xb92"}
+	mc.Blocks[92] = &chain.Block{Header: chain.Header{Number: 92, Hash: "0xb92"}}
+
+	mock.ExpectQuery(pendingQuery).WithArgs(1, 76, sqlmock.AnyArg()).WillReturnRows(depositRows().
+		AddRow(1, 1, "0xabc", -1, 0, "0xaddr", nil, 1000, 13, 90, "0xb90", "credited", time.Now(), false, nil).
+		AddRow(2, 1, "0xdef", -1, 0, "0xaddr", nil, 500, 13, 92, "0xb92", "credited", time.Now(), false, nil))
+	mock.ExpectBegin()
+	expectTransition(mock, 1, models.StatusCredited, models.StatusReorged)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT chain_id, address, token, amount FROM deposits WHERE id = $1")).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"chain_id", "address", "token", "amount"}).AddRow(1, "0xaddr", nil, 1000))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE accounts SET balance = balance - $1 WHERE chain_id = $2 AND address = $3")).WithArgs("1000", 1, "0xaddr").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET reorged_at = $1 WHERE id = $2")).WithArgs(sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audits(deposit_id, action, details, created_at) VALUES($1, $2, $3, $4)")).
+		WithArgs(1, "reorg_reversed", jsonContains{`"reason":"moved"`, `"block_hash":"0xb90"`, `"new_block_hash":"0xb95"`}, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET confirmations = $1 WHERE id = $2")).WithArgs(19, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	svc := NewServiceWithStore(DefaultConfig(), st.New(db), mc)
+	alerts := &recordingAlerter{}
+	svc.procs[0].alerter = alerts
+	if err := svc.ProcessOnce(context.Background()); err != nil {
+		t.Fatalf("ProcessOnce error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+	if len(alerts.alerts) != 1 || alerts.alerts[0].Kind != "credit_reversed" || alerts.alerts[0].DepositID != 1 {
+		t.Fatalf("expected one credit_reversed alert for deposit 1, got %+v", alerts.alerts)
+	}
+}
+
+// A lookup that finds nothing only reverses a credit once the block it was credited in
+// is no longer canonical: a node that lost the transaction must not claw back a credit.
+func TestProcessOnce_ReversesMissingCreditOnlyWhenBlockOrphaned(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	// neither transaction is found; block 90 is still canonical, block 91 was replaced
+	mc := chain.NewMock()
+	mc.Block = 110
+	mc.Blocks[90] = &chain.Block{Header: chain.Header{Number: 90, Hash: "0xb90"}}
+	mc.Blocks[91] = &chain.Block{Header: chain.Header{Number: 91, Hash: "0xother"}}
+
+	mock.ExpectQuery(pendingQuery).WithArgs(1, 76, sqlmock.AnyArg()).WillReturnRows(depositRows().
+		AddRow(1, 1, "0xabc", -1, 0, "0xaddr", nil, 1000, 13, 90, "0xb90", "credited", time.Now(), false, nil).
+		AddRow(2, 1, "0xdef", -1, 0, "0xaddr", nil, 500, 13, 91, "0xb91", "credited", time.Now(), false, nil))
+	mock.ExpectBegin()
+	expectTransition(mock, 2, models.StatusCredited, models.StatusReorged)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT chain_id, address, token, amount FROM deposits WHERE id = $1")).WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"chain_id", "address", "token", "amount"}).AddRow(1, "0xaddr", nil, 500))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE accounts SET balance = balance - $1 WHERE chain_id = $2 AND address = $3")).WithArgs("500", 1, "0xaddr").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET reorged_at = $1 WHERE id = $2")).WithArgs(sqlmock.AnyArg(), 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audits(deposit_id, action, details, created_at) VALUES($1, $2, $3, $4)")).
+		WithArgs(2, "reorg_reversed", jsonContains{`"reason":"missing"`, `"block_hash":"0xb91"`}, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	svc := NewServiceWithStore(DefaultConfig(), st.New(db), mc)
+	alerts := &recordingAlerter{}
+	svc.procs[0].alerter = alerts
+	if err := svc.ProcessOnce(context.Background()); err != nil {
+		t.Fatalf("ProcessOnce error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+	if len(alerts.alerts) != 1 || alerts.alerts[0].DepositID != 2 {
+		t.Fatalf("expected one credit_reversed alert for deposit 2, got %+v", alerts.alerts)
+	}
+}
+
+// A receipt cached before a reorg the header tracker never reported still names the old
+// block. It must not keep a credit alive: the block is checked against the canonical
+// header, the stale entry dropped, and the next cycle sees the transaction moved.
+func TestProcessOnce_WatchDoesNotTrustStaleCachedReceipt(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	mc := chain.NewMock()
+	mc.Block = 110
+	mc.TxInfo["0xabc"] = struct {
+		Block    uint64
+		Hash     string
+		Reverted bool
+	}{Block: 90, Hash: "0xb90"}
+	cc := chain.NewCaching(mc, 16)
+	if _, err := cc.Receipts(context.Background(), []string{"0xabc"}); err != nil {
+		t.Fatalf("Receipts: %v", err)
+	}
+	// the reorg replaced block 90 and re-included the transaction at block 95
+	mc.Blocks[90] = &chain.Block{Header: chain.Header{Number: 90, Hash: "0xnew90"}}
+	mc.TxInfo["0xabc"] = struct {
+		Block    uint64
+		Hash     string
+		Reverted bool
+	}{Block: 95, Hash: "0xb95"}
+
+	credited := func() *sqlmock.Rows {
+		return depositRows().AddRow(1, 1, "0xabc", -1, 0, "0xaddr", nil, 1000, 13, 90, "0xb90", "credited", time.Now(), false, nil)
+	}
+	mock.ExpectQuery(pendingQuery).WithArgs(1, 76, sqlmock.AnyArg()).WillReturnRows(credited())
+	mock.ExpectQuery(pendingQuery).WithArgs(1, 76, sqlmock.AnyArg()).WillReturnRows(credited())
+	mock.ExpectBegin()
+	expectTransition(mock, 1, models.StatusCredited, models.StatusReorged)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT chain_id, address, token, amount FROM deposits WHERE id = $1")).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"chain_id", "address", "token", "amount"}).AddRow(1, "0xaddr", nil, 1000))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE accounts SET balance = balance - $1 WHERE chain_id = $2 AND address = $3")).WithArgs("1000", 1, "0xaddr").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET reorged_at = $1 WHERE id = $2")).WithArgs(sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audits(deposit_id, action, details, created_at) VALUES($1, $2, $3, $4)")).
+		WithArgs(1, "reorg_reversed", jsonContains{`"reason":"moved"`, `"new_block_hash":"0xb95"`}, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	svc := NewServiceWithStore(DefaultConfig(), st.New(db), cc)
+	svc.procs[0].alerter = &recordingAlerter{}
+	for i := 0; i < 2; i++ {
+		if err := svc.ProcessOnce(context.Background()); err != nil {
+			t.Fatalf("ProcessOnce %d error: %v", i, err)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestWebhookAlerter_PostsJSON(t *testing.T) {
+	var got Alert
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := DefaultConfig()
+	cfg.AlertWebhook = srv.URL
+	if err := newAlerter(cfg).Alert(context.Background(), Alert{Kind: "credit_reversed", ChainID: 1, DepositID: 7, Message: "reversed"}); err != nil {
+		t.Fatalf("Alert: %v", err)
+	}
+	if got.Kind != "credit_reversed" || got.DepositID != 7 {
+		t.Fatalf("unexpected alert %+v", got)
+	}
+}
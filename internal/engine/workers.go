@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"github.com/namtran/creditengine/internal/models"
+)
+
+// workers is how many goroutines a processing cycle may use, at least one.
+func (p *chainProcessor) workers() int {
+	if p.cfg.Workers < 1 {
+		return 1
+	}
+	return p.cfg.Workers
+}
+
+// runBounded calls fn(i) for i in [0, n) on up to workers goroutines and waits for all of
+// them. Once ctx is done no further calls are started.
+func runBounded(ctx context.Context, workers, n int, fn func(i int)) {
+	if workers > n {
+		workers = n
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+dispatch:
+	for i := 0; i < n; i++ {
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case jobs <- i:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// forEachDeposit calls fn for every deposit on the chain's worker pool. Deposits of one
+// address go to the same worker in their original order, so an account's credits and
+// reversals are applied in sequence. A panicking deposit is logged and ends its address's
+// run for this cycle without touching other addresses; the rest are picked up next cycle.
+// It returns ctx's error if the cycle was cancelled.
+func (p *chainProcessor) forEachDeposit(ctx context.Context, deposits []models.Deposit, fn func(models.Deposit)) error {
+	var groups [][]models.Deposit
+	index := make(map[string]int)
+	for _, d := range deposits {
+		key := strings.ToLower(d.Address)
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], d)
+	}
+
+	runBounded(ctx, p.workers(), len(groups), func(i int) {
+		for _, d := range groups[i] {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := safely(func() { fn(d) }); err != nil {
+				log.Printf("chain %d deposit %s: %v; skipping the rest of %s this cycle", p.cfg.ChainID, d.TxHash, err, d.Address)
+				return
+			}
+		}
+	})
+	return ctx.Err()
+}
+
+// safely runs fn, turning a panic into an error.
+func safely(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	fn()
+	return nil
+}
@@ -0,0 +1,182 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/namtran/creditengine/internal/chain"
+	"github.com/namtran/creditengine/internal/models"
+)
+
+func workerDeposits(addresses, perAddress int) []models.Deposit {
+	var deps []models.Deposit
+	for n := 0; n < perAddress; n++ {
+		for a := 0; a < addresses; a++ {
+			deps = append(deps, models.Deposit{ID: int64(len(deps) + 1), TxHash: fmt.Sprintf("0x%d", len(deps)+1), Address: fmt.Sprintf("0xa%d", a)})
+		}
+	}
+	return deps
+}
+
+func TestForEachDeposit_OrdersEachAddressAndBoundsWorkers(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Workers = 4
+	p := &chainProcessor{cfg: cfg}
+	deps := workerDeposits(10, 5)
+
+	var mu sync.Mutex
+	seen := make(map[string][]int64)
+	busy := make(map[string]bool)
+	var running, peak int32
+	err := p.forEachDeposit(context.Background(), deps, func(d models.Deposit) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		mu.Lock()
+		if n > peak {
+			peak = n
+		}
+		if busy[d.Address] {
+			t.Errorf("address %s handled by two workers at once", d.Address)
+		}
+		busy[d.Address] = true
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		busy[d.Address] = false
+		seen[d.Address] = append(seen[d.Address], d.ID)
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatalf("forEachDeposit error: %v", err)
+	}
+
+	if peak > 4 {
+		t.Fatalf("expected at most 4 deposits at once, got %d", peak)
+	}
+	for _, d := range deps {
+		ids := seen[d.Address]
+		if len(ids) != 5 {
+			t.Fatalf("expected 5 deposits for %s, got %v", d.Address, ids)
+		}
+		for i := 1; i < len(ids); i++ {
+			if ids[i] < ids[i-1] {
+				t.Fatalf("deposits of %s out of order: %v", d.Address, ids)
+			}
+		}
+	}
+}
+
+// A panic skips the rest of its address for the cycle and leaves the other addresses alone.
+func TestForEachDeposit_IsolatesPanics(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Workers = 3
+	p := &chainProcessor{cfg: cfg}
+	deps := workerDeposits(3, 3)
+
+	var mu sync.Mutex
+	var done []int64
+	err := p.forEachDeposit(context.Background(), deps, func(d models.Deposit) {
+		if d.ID == 2 {
+			panic("boom")
+		}
+		mu.Lock()
+		done = append(done, d.ID)
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatalf("forEachDeposit error: %v", err)
+	}
+
+	// 0xa1 holds 2, 5 and 8: 5 and 8 wait for the next cycle
+	if len(done) != 6 {
+		t.Fatalf("expected 6 deposits handled, got %v", done)
+	}
+	for _, id := range done {
+		if id == 5 || id == 8 {
+			t.Fatalf("deposit %d handled after its address panicked: %v", id, done)
+		}
+	}
+}
+
+func TestForEachDeposit_StopsWhenCancelled(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Workers = 2
+	p := &chainProcessor{cfg: cfg}
+	deps := workerDeposits(20, 5)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var handled int32
+	err := p.forEachDeposit(ctx, deps, func(d models.Deposit) {
+		if atomic.AddInt32(&handled, 1) == 3 {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	// each worker may finish the deposit it was on
+	if n := atomic.LoadInt32(&handled); n > 4 {
+		t.Fatalf("expected the cycle to stop after cancellation, %d deposits handled", n)
+	}
+}
+
+// slowChain serves receipt lookups one at a time per call, without batching.
+type slowChain struct {
+	running, peak int32
+	circuitAt     string
+}
+
+func (c *slowChain) BlockNumber(ctx context.Context) (uint64, error) { return 100, nil }
+
+func (c *slowChain) Confirmations(ctx context.Context, txBlockNumber uint64) (uint64, error) {
+	return 100 - txBlockNumber + 1, nil
+}
+
+func (c *slowChain) ConfirmationsFromTxHash(ctx context.Context, txHash string) (uint64, uint64, string, bool, bool, error) {
+	n := atomic.AddInt32(&c.running, 1)
+	defer atomic.AddInt32(&c.running, -1)
+	for {
+		peak := atomic.LoadInt32(&c.peak)
+		if n <= peak || atomic.CompareAndSwapInt32(&c.peak, peak, n) {
+			break
+		}
+	}
+	if txHash == c.circuitAt {
+		return 0, 0, "", false, false, chain.ErrCircuitOpen
+	}
+	time.Sleep(5 * time.Millisecond)
+	return 90, 11, "0xb90", true, false, nil
+}
+
+func TestTxStatuses_LooksUpInParallel(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Workers = 8
+	sc := &slowChain{}
+	p := &chainProcessor{cfg: cfg, chain: sc}
+	deps := workerDeposits(32, 1)
+
+	res, err := p.txStatuses(context.Background(), deps, 100)
+	if err != nil {
+		t.Fatalf("txStatuses error: %v", err)
+	}
+	if len(res) != 32 {
+		t.Fatalf("expected 32 statuses, got %d", len(res))
+	}
+	if sc.peak < 2 || sc.peak > 8 {
+		t.Fatalf("expected between 2 and 8 lookups at once, got %d", sc.peak)
+	}
+
+	// an open circuit still stops the whole cycle
+	sc.circuitAt = "0x1"
+	if _, err := p.txStatuses(context.Background(), deps, 100); !errors.Is(err, chain.ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package hd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var errChecksum = errors.New("hd: bad base58 checksum")
+
+func checksum(data []byte) []byte {
+	h := sha256.Sum256(data)
+	h = sha256.Sum256(h[:])
+	return h[:4]
+}
+
+func base58CheckEncode(data []byte) string {
+	payload := append(append([]byte(nil), data...), checksum(data)...)
+	n := new(big.Int).SetBytes(payload)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range payload {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+func base58CheckDecode(s string) ([]byte, error) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, r := range s {
+		i := bytes.IndexRune([]byte(base58Alphabet), r)
+		if i < 0 {
+			return nil, errors.New("hd: invalid base58 character")
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(i)))
+	}
+	data := n.Bytes()
+	for _, r := range s {
+		if r != rune(base58Alphabet[0]) {
+			break
+		}
+		data = append([]byte{0}, data...)
+	}
+	if len(data) < 4 {
+		return nil, errChecksum
+	}
+	payload, sum := data[:len(data)-4], data[len(data)-4:]
+	if !bytes.Equal(checksum(payload), sum) {
+		return nil, errChecksum
+	}
+	return payload, nil
+}
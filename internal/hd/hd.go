@@ -0,0 +1,147 @@
+// Package hd derives deposit addresses from a BIP32 extended public key. Only public
+// derivation is implemented, so the engine never holds private keys.
+package hd
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/crypto/ripemd160" //nolint:staticcheck // BIP32 fingerprints are defined with RIPEMD-160
+)
+
+// HardenedOffset is the first hardened child index; hardened children cannot be derived
+// from a public key.
+const HardenedOffset uint32 = 0x80000000
+
+var (
+	// ErrHardened is returned when asked for a hardened child of a public key.
+	ErrHardened = errors.New("hd: hardened derivation needs the private key")
+	// ErrInvalidChild is returned for the (astronomically rare) indexes BIP32 skips.
+	ErrInvalidChild = errors.New("hd: invalid child, skip to the next index")
+)
+
+// xpubVersion is the BIP32 mainnet public key version ("xpub").
+var xpubVersion = [4]byte{0x04, 0x88, 0xb2, 0x1e}
+
+// ExtendedKey is a BIP32 extended public key.
+type ExtendedKey struct {
+	Depth       uint8
+	ParentFP    [4]byte
+	ChildNumber uint32
+	ChainCode   [32]byte
+	// PubKey is the 33-byte compressed public key.
+	PubKey [33]byte
+}
+
+// ParseXPub decodes a base58check "xpub..." string.
+func ParseXPub(s string) (*ExtendedKey, error) {
+	data, err := base58CheckDecode(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 78 {
+		return nil, fmt.Errorf("hd: extended key is %d bytes, want 78", len(data))
+	}
+	if !bytes.Equal(data[:4], xpubVersion[:]) {
+		return nil, errors.New("hd: not an xpub (private or non-mainnet key?)")
+	}
+	k := &ExtendedKey{Depth: data[4], ChildNumber: binary.BigEndian.Uint32(data[9:13])}
+	copy(k.ParentFP[:], data[5:9])
+	copy(k.ChainCode[:], data[13:45])
+	copy(k.PubKey[:], data[45:78])
+	if _, err := crypto.DecompressPubkey(k.PubKey[:]); err != nil {
+		return nil, fmt.Errorf("hd: %w", err)
+	}
+	return k, nil
+}
+
+// String encodes k as a base58check "xpub..." string.
+func (k *ExtendedKey) String() string {
+	data := make([]byte, 0, 78)
+	data = append(data, xpubVersion[:]...)
+	data = append(data, k.Depth)
+	data = append(data, k.ParentFP[:]...)
+	data = binary.BigEndian.AppendUint32(data, k.ChildNumber)
+	data = append(data, k.ChainCode[:]...)
+	data = append(data, k.PubKey[:]...)
+	return base58CheckEncode(data)
+}
+
+// Child derives the non-hardened child i (BIP32 CKDpub).
+func (k *ExtendedKey) Child(i uint32) (*ExtendedKey, error) {
+	if i >= HardenedOffset {
+		return nil, ErrHardened
+	}
+	mac := hmac.New(sha512.New, k.ChainCode[:])
+	mac.Write(k.PubKey[:])
+	_ = binary.Write(mac, binary.BigEndian, i)
+	sum := mac.Sum(nil)
+
+	curve := crypto.S256()
+	il := new(big.Int).SetBytes(sum[:32])
+	if il.Cmp(curve.Params().N) >= 0 {
+		return nil, ErrInvalidChild
+	}
+	parent, err := crypto.DecompressPubkey(k.PubKey[:])
+	if err != nil {
+		return nil, err
+	}
+	x, y := curve.ScalarBaseMult(sum[:32])
+	x, y = curve.Add(x, y, parent.X, parent.Y)
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return nil, ErrInvalidChild
+	}
+
+	child := &ExtendedKey{Depth: k.Depth + 1, ChildNumber: i}
+	copy(child.ParentFP[:], k.fingerprint())
+	copy(child.ChainCode[:], sum[32:])
+	parent.X, parent.Y = x, y
+	copy(child.PubKey[:], crypto.CompressPubkey(parent))
+	return child, nil
+}
+
+// Derive walks a path of non-hardened child indexes from k.
+func (k *ExtendedKey) Derive(path ...uint32) (*ExtendedKey, error) {
+	for _, i := range path {
+		var err error
+		if k, err = k.Child(i); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Address returns the lower-cased Ethereum address of k's public key.
+func (k *ExtendedKey) Address() (string, error) {
+	pub, err := crypto.DecompressPubkey(k.PubKey[:])
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(crypto.PubkeyToAddress(*pub).Hex()), nil
+}
+
+// fingerprint is the first 4 bytes of HASH160(pubkey), identifying k as a parent.
+func (k *ExtendedKey) fingerprint() []byte {
+	sha := sha256.Sum256(k.PubKey[:])
+	h := ripemd160.New()
+	h.Write(sha[:])
+	return h.Sum(nil)[:4]
+}
+
+// DepositAddress derives the Ethereum deposit address with the given index from an
+// account-level xpub (m/44'/60'/account'), following BIP44's external chain: .../0/index.
+func DepositAddress(xpub *ExtendedKey, index uint32) (string, error) {
+	k, err := xpub.Derive(0, index)
+	if err != nil {
+		return "", err
+	}
+	return k.Address()
+}
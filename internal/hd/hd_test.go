@@ -0,0 +1,121 @@
+package hd
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// xprv is a private extended key, used only to build test xpubs the way a wallet would.
+type xprv struct {
+	key       *big.Int
+	chainCode []byte
+	pub       *ExtendedKey
+}
+
+func master(t *testing.T, seedHex string) *xprv {
+	t.Helper()
+	seed, err := hex.DecodeString(seedHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	return newXprv(t, new(big.Int).SetBytes(sum[:32]), sum[32:], 0, [4]byte{}, 0)
+}
+
+func newXprv(t *testing.T, key *big.Int, chainCode []byte, depth uint8, parentFP [4]byte, child uint32) *xprv {
+	t.Helper()
+	prv, err := crypto.ToECDSA(key.FillBytes(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := &ExtendedKey{Depth: depth, ParentFP: parentFP, ChildNumber: child}
+	copy(pub.ChainCode[:], chainCode)
+	copy(pub.PubKey[:], crypto.CompressPubkey(&prv.PublicKey))
+	return &xprv{key: key, chainCode: chainCode, pub: pub}
+}
+
+// child is BIP32 CKDpriv.
+func (k *xprv) child(t *testing.T, i uint32) *xprv {
+	t.Helper()
+	mac := hmac.New(sha512.New, k.chainCode)
+	if i >= HardenedOffset {
+		mac.Write([]byte{0})
+		mac.Write(k.key.FillBytes(make([]byte, 32)))
+	} else {
+		mac.Write(k.pub.PubKey[:])
+	}
+	_ = binary.Write(mac, binary.BigEndian, i)
+	sum := mac.Sum(nil)
+	key := new(big.Int).Add(new(big.Int).SetBytes(sum[:32]), k.key)
+	key.Mod(key, crypto.S256().Params().N)
+	var fp [4]byte
+	copy(fp[:], k.pub.fingerprint())
+	return newXprv(t, key, sum[32:], k.pub.Depth+1, fp, i)
+}
+
+func TestChild_MatchesBIP32TestVector1(t *testing.T) {
+	m0h := master(t, "000102030405060708090a0b0c0d0e0f").child(t, HardenedOffset)
+	if got, want := m0h.pub.String(), "xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw"; got != want {
+		t.Fatalf("m/0H xpub:\n got %s\nwant %s", got, want)
+	}
+
+	xpub, err := ParseXPub(m0h.pub.String())
+	if err != nil {
+		t.Fatalf("ParseXPub: %v", err)
+	}
+	child, err := xpub.Child(1)
+	if err != nil {
+		t.Fatalf("Child: %v", err)
+	}
+	if got, want := child.String(), "xpub6ASuArnXKPbfEwhqN6e3mwBcDTgzisQN1wXN9BJcM47sSikHjJf3UFHKkNAWbWMiGj7Wf5uMash7SyYq527Hqck2AxYysAA7xmALppuCkwQ"; got != want {
+		t.Fatalf("m/0H/1 xpub:\n got %s\nwant %s", got, want)
+	}
+
+	if _, err := xpub.Child(HardenedOffset); !errors.Is(err, ErrHardened) {
+		t.Fatalf("expected ErrHardened, got %v", err)
+	}
+}
+
+func TestDepositAddress_BIP44Ethereum(t *testing.T) {
+	// seed of the mnemonic "abandon abandon ... about" without passphrase
+	m := master(t, "5eb00bbddcf069084889a8ab9155568165f5c453ccb85e70811aaed6f6da5fc19a5ac40b389cd370d086206dec8aa6c43daea6690f20ad3d8d48b2d2ce9e38e4")
+	account := m.child(t, HardenedOffset+44).child(t, HardenedOffset+60).child(t, HardenedOffset)
+
+	xpub, err := ParseXPub(account.pub.String())
+	if err != nil {
+		t.Fatalf("ParseXPub: %v", err)
+	}
+	addr, err := DepositAddress(xpub, 0)
+	if err != nil {
+		t.Fatalf("DepositAddress: %v", err)
+	}
+	if addr != "0x9858effd232b4033e47d90003d41ec34ecaeda94" {
+		t.Fatalf("unexpected m/44'/60'/0'/0/0 address %s", addr)
+	}
+	next, _ := DepositAddress(xpub, 1)
+	if next == addr {
+		t.Fatalf("index 1 must yield a different address")
+	}
+}
+
+func TestParseXPub_RejectsBadInput(t *testing.T) {
+	good := "xpub6ASuArnXKPbfEwhqN6e3mwBcDTgzisQN1wXN9BJcM47sSikHjJf3UFHKkNAWbWMiGj7Wf5uMash7SyYq527Hqck2AxYysAA7xmALppuCkwQ"
+	for _, s := range []string{
+		good[:len(good)-1] + "R", // checksum
+		"xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi",
+		"0OIl",
+	} {
+		if _, err := ParseXPub(s); err == nil {
+			t.Fatalf("expected error for %q", s)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math/big"
+)
+
+// Amount is a quantity in an asset's base units (wei, token units, satoshis). It is stored
+// as numeric(78,0), which holds any uint256, so no on-chain value has to be truncated. The
+// zero value is 0.
+type Amount struct {
+	v *big.Int
+}
+
+// NewAmount returns v as an Amount; v is copied.
+func NewAmount(v *big.Int) Amount {
+	if v == nil {
+		return Amount{}
+	}
+	return Amount{v: new(big.Int).Set(v)}
+}
+
+// Int64Amount returns n as an Amount.
+func Int64Amount(n int64) Amount {
+	return Amount{v: big.NewInt(n)}
+}
+
+// Big returns a copy of a as a big.Int.
+func (a Amount) Big() *big.Int {
+	if a.v == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Set(a.v)
+}
+
+func (a Amount) String() string {
+	return a.Big().String()
+}
+
+// Value stores a as a decimal string, which Postgres casts to numeric.
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+// Scan reads a numeric column, which lib/pq returns as its decimal text.
+func (a *Amount) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case int64:
+		a.v = big.NewInt(v)
+		return nil
+	case []byte:
+		return a.parse(string(v))
+	case string:
+		return a.parse(v)
+	}
+	return fmt.Errorf("amount: cannot scan %T", src)
+}
+
+func (a *Amount) parse(s string) error {
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return fmt.Errorf("amount: invalid value %q", s)
+	}
+	a.v = v
+	return nil
+}
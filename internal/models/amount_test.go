@@ -0,0 +1,33 @@
+package models
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestAmount_RoundTripsUint256(t *testing.T) {
+	max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	v, err := NewAmount(max).Value()
+	if err != nil {
+		t.Fatalf("Value: %v", err)
+	}
+
+	// lib/pq returns numeric as text
+	var a Amount
+	if err := a.Scan([]byte(v.(string))); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if a.Big().Cmp(max) != 0 {
+		t.Fatalf("expected %s, got %s", max, a)
+	}
+
+	if err := a.Scan(int64(7)); err != nil || a.String() != "7" {
+		t.Fatalf("expected 7, got %s (%v)", a, err)
+	}
+	if err := a.Scan("1.5"); err == nil {
+		t.Fatalf("expected a fractional amount to be rejected")
+	}
+	if (Amount{}).String() != "0" {
+		t.Fatalf("expected the zero Amount to be 0")
+	}
+}
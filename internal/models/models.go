@@ -5,20 +5,116 @@ import (
 	"time"
 )
 
+// Deposit is one incoming transfer. It is identified by (ChainID, TxHash, LogIndex,
+// TraceIndex); native transfers have LogIndex -1 and no Token, and internal transfers made
+// by a contract have TraceIndex >= 1.
 type Deposit struct {
 	ID            int64
+	ChainID       uint64
 	TxHash        string
+	LogIndex      int64
+	TraceIndex    int64
 	Address       string
-	Amount        int64
+	Token         sql.NullString
+	Amount        Amount
 	Confirmations uint64
 	TxBlock       sql.NullInt64
 	BlockHash     sql.NullString
-	Status        string
+	Status        DepositStatus
 	ReceivedAt    time.Time
+	// NeedsFlush marks a deposit paid to the account's CREATE2 forwarder: the funds stay
+	// there until the forwarder is deployed (if it is not yet) and flushed.
+	NeedsFlush bool
+	// Recipient is the address the chain paid when it is not Address: the account's
+	// forwarder (accounts.forwarder_address) at discovery. It never changes afterwards,
+	// so verification does not depend on NeedsFlush or on the forwarder config.
+	Recipient sql.NullString
 }
 
 type Account struct {
 	ID      int64
+	ChainID uint64
 	Address string
-	Balance int64
+	Balance Amount
+	// DerivationIndex is set for addresses allocated from the HD xpub.
+	DerivationIndex sql.NullInt64
+	// ForwarderAddress is the account's counterfactual CREATE2 forwarder, if any.
+	ForwarderAddress sql.NullString
+}
+
+// Checkpoint is the last block a scanner has fully processed.
+type Checkpoint struct {
+	ChainID     uint64
+	Name        string
+	BlockNumber uint64
+	BlockHash   string
+	ParentHash  string
+}
+
+// Reorg is a detected chain reorganisation: blocks FromBlock..ToBlock were orphaned.
+type Reorg struct {
+	ChainID          uint64
+	FromBlock        uint64
+	ToBlock          uint64
+	Depth            uint64
+	OrphanedHeadHash string
+}
+
+// FinalityEvidence records why a deposit was considered final when it was credited.
+type FinalityEvidence struct {
+	Mode          string `json:"mode"`
+	TxBlock       uint64 `json:"tx_block"`
+	BlockHash     string `json:"block_hash"`
+	Confirmations uint64 `json:"confirmations"`
+	// TaggedBlock/TaggedHash are the safe or finalized head the deposit was checked against.
+	TaggedBlock uint64 `json:"tagged_block,omitempty"`
+	TaggedHash  string `json:"tagged_hash,omitempty"`
+	// Policy is the confirmation rule the deposit was held to.
+	Policy *PolicyDecision `json:"policy,omitempty"`
+}
+
+// PolicyDecision records which confirmation rule applied to a deposit: a tier of the
+// chain's confirmation policy, or Tier -1 for the chain default.
+type PolicyDecision struct {
+	Tier          int    `json:"tier"`
+	Asset         string `json:"asset,omitempty"`
+	Below         string `json:"below,omitempty"`
+	Mode          string `json:"mode"`
+	Confirmations uint64 `json:"confirmations,omitempty"`
+}
+
+// MismatchEvidence records what the chain showed for a deposit whose row disagrees with
+// it. Reason names the first field that differed: "missing" when the chain has no
+// matching transfer at all, or "recipient", "token" or "amount". The other fields are
+// the chain's values, Amount as a decimal string.
+type MismatchEvidence struct {
+	Reason    string `json:"reason"`
+	TxBlock   uint64 `json:"tx_block"`
+	BlockHash string `json:"block_hash"`
+	Recipient string `json:"recipient,omitempty"`
+	Token     string `json:"token,omitempty"`
+	Amount    string `json:"amount,omitempty"`
+}
+
+// ReorgReversal records why a credited deposit was taken back during its watch window.
+// Reason is "missing" when the transaction is no longer on the canonical chain, "moved"
+// when it was re-included in another block, or "reverted". NewTxBlock and NewBlockHash
+// are set for a moved transaction.
+type ReorgReversal struct {
+	Reason        string `json:"reason"`
+	TxBlock       uint64 `json:"tx_block"`
+	BlockHash     string `json:"block_hash"`
+	Confirmations uint64 `json:"confirmations"`
+	NewTxBlock    uint64 `json:"new_tx_block,omitempty"`
+	NewBlockHash  string `json:"new_block_hash,omitempty"`
+}
+
+// Reinclusion records a reorged deposit's transaction being mined again: the block it was
+// reorged out of and the canonical block it reappeared in.
+type Reinclusion struct {
+	TxBlock       uint64 `json:"tx_block"`
+	BlockHash     string `json:"block_hash"`
+	NewTxBlock    uint64 `json:"new_tx_block"`
+	NewBlockHash  string `json:"new_block_hash"`
+	Confirmations uint64 `json:"confirmations"`
 }
@@ -0,0 +1,48 @@
+package models
+
+// DepositStatus is a deposit's state. The store only moves a deposit along the
+// transitions CanTransition allows, and records each move in deposit_status_history.
+type DepositStatus string
+
+const (
+	// StatusPending deposits are confirming.
+	StatusPending DepositStatus = "pending"
+	// StatusCredited deposits have been added to the account's balance.
+	StatusCredited DepositStatus = "credited"
+	// StatusReorged deposits' transactions left the canonical chain. They may return to
+	// pending if the transaction is mined again.
+	StatusReorged DepositStatus = "reorged"
+	// StatusFailed deposits' transactions were mined but reverted.
+	StatusFailed DepositStatus = "failed"
+	// StatusMismatch deposits disagree with the chain's record of their transfer.
+	StatusMismatch DepositStatus = "mismatch"
+	// StatusReversed deposits had their credit taken back by an operator.
+	StatusReversed DepositStatus = "reversed"
+)
+
+// transitions lists the statuses each status may move to. Failed, mismatch and reversed
+// are terminal.
+var transitions = map[DepositStatus][]DepositStatus{
+	StatusPending:  {StatusCredited, StatusReorged, StatusFailed, StatusMismatch},
+	StatusCredited: {StatusReorged, StatusFailed, StatusReversed},
+	StatusReorged:  {StatusPending},
+}
+
+// Valid reports whether s is a known status.
+func (s DepositStatus) Valid() bool {
+	switch s {
+	case StatusPending, StatusCredited, StatusReorged, StatusFailed, StatusMismatch, StatusReversed:
+		return true
+	}
+	return false
+}
+
+// CanTransition reports whether a deposit in status s may move to status to.
+func (s DepositStatus) CanTransition(to DepositStatus) bool {
+	for _, t := range transitions[s] {
+		if t == to {
+			return true
+		}
+	}
+	return false
+}
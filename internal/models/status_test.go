@@ -0,0 +1,25 @@
+package models
+
+import "testing"
+
+func TestDepositStatus_CanTransition(t *testing.T) {
+	cases := []struct {
+		from, to DepositStatus
+		ok       bool
+	}{
+		{StatusPending, StatusCredited, true},
+		{StatusPending, StatusFailed, true},
+		{StatusPending, StatusReversed, false},
+		{StatusCredited, StatusPending, false},
+		{StatusCredited, StatusReversed, true},
+		{StatusReorged, StatusPending, true},
+		{StatusReorged, StatusCredited, false},
+		{StatusFailed, StatusPending, false},
+		{StatusMismatch, StatusCredited, false},
+	}
+	for _, c := range cases {
+		if got := c.from.CanTransition(c.to); got != c.ok {
+			t.Errorf("%s -> %s: got %v, want %v", c.from, c.to, got, c.ok)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"strings"
+
+	"github.com/namtran/creditengine/internal/models"
+)
+
+// AllocateAccount creates an account on chainID for the next unused derivation index.
+// derive maps an index to its address and, optionally, its forwarder address; it returns
+// "" for an index that must be skipped, which then stays consumed. The index counter and
+// the account are written in one transaction, so concurrent callers never get the same
+// index.
+func (s *Store) AllocateAccount(ctx context.Context, chainID uint64, derive func(index uint32) (address, forwarder string, err error)) (*models.Account, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	acct := &models.Account{ChainID: chainID}
+	for acct.Address == "" {
+		var index int64
+		err = tx.QueryRowContext(ctx, `INSERT INTO hd_counters(chain_id, next_index) VALUES($1, 1) ON CONFLICT (chain_id) DO UPDATE SET next_index = hd_counters.next_index + 1 RETURNING next_index - 1`, chainID).Scan(&index)
+		if err != nil {
+			return nil, err
+		}
+		if index > math.MaxInt32 {
+			// BIP32 indexes above 2^31-1 are hardened and cannot come from an xpub
+			err = errors.New("all non-hardened derivation indexes are used")
+			return nil, err
+		}
+		var forwarder string
+		if acct.Address, forwarder, err = derive(uint32(index)); err != nil {
+			return nil, err
+		}
+		acct.ForwarderAddress = sql.NullString{String: forwarder, Valid: forwarder != ""}
+		acct.DerivationIndex = sql.NullInt64{Int64: index, Valid: true}
+	}
+
+	err = tx.QueryRowContext(ctx, `INSERT INTO accounts(chain_id, address, balance, derivation_index, forwarder_address) VALUES($1, $2, 0, $3, $4) RETURNING id`,
+		chainID, acct.Address, acct.DerivationIndex, acct.ForwarderAddress).Scan(&acct.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return acct, nil
+}
+
+// ForwarderAddresses returns a chain's forwarder addresses, keyed by their lower-cased
+// form, mapped to the address of the account that owns each.
+func (s *Store) ForwarderAddresses(ctx context.Context, chainID uint64) (map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT forwarder_address, address FROM accounts WHERE chain_id = $1 AND forwarder_address IS NOT NULL`, chainID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	res := make(map[string]string)
+	for rows.Next() {
+		var fwd, addr string
+		if err := rows.Scan(&fwd, &addr); err != nil {
+			return nil, err
+		}
+		res[strings.ToLower(fwd)] = addr
+	}
+	return res, rows.Err()
+}
+
+// ErrNotFlushable is returned by MarkFlushed for a deposit that does not exist or is not
+// credited; only credited funds may be swept out of a forwarder.
+var ErrNotFlushable = errors.New("deposit not found or not credited")
+
+// DepositsNeedingFlush returns a chain's credited deposits still sitting in forwarders.
+// Deposits not credited yet are left out: they may still be reorged or fail verification.
+func (s *Store) DepositsNeedingFlush(ctx context.Context, chainID uint64) ([]models.Deposit, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+depositColumns+` FROM deposits WHERE chain_id = $1 AND needs_flush AND status = 'credited' ORDER BY id`, chainID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	return scanDeposits(rows)
+}
+
+// MarkFlushed clears the needs-flush flag once a credited deposit's forwarder has been
+// deployed and its funds swept. Clearing it again is a no-op; an unknown or uncredited
+// deposit is ErrNotFlushable.
+func (s *Store) MarkFlushed(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE deposits SET needs_flush = false WHERE id = $1 AND status = 'credited'`, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFlushable
+	}
+	return nil
+}
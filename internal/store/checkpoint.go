@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/namtran/creditengine/internal/models"
+)
+
+// LoadCheckpoint returns a chain's named scanner checkpoint, or nil if the scanner has not
+// committed a block yet.
+func (s *Store) LoadCheckpoint(ctx context.Context, chainID uint64, name string) (*models.Checkpoint, error) {
+	cp := models.Checkpoint{ChainID: chainID, Name: name}
+	err := s.db.QueryRowContext(ctx, `SELECT block_number, block_hash FROM scan_checkpoints WHERE chain_id = $1 AND name = $2`, chainID, name).Scan(&cp.BlockNumber, &cp.BlockHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// CommitBlock records the deposits found in a block and advances the checkpoint to it in
+// one transaction, so a crash never leaves the checkpoint ahead of (or behind) its deposits.
+// Scanned block hashes older than keep blocks are pruned.
+func (s *Store) CommitBlock(ctx context.Context, cp models.Checkpoint, deposits []models.Deposit, keep uint64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	for _, d := range deposits {
+		if err = insertDeposit(ctx, tx, d); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO scanned_blocks(chain_id, number, hash, parent_hash) VALUES($1, $2, $3, $4) ON CONFLICT (chain_id, number) DO UPDATE SET hash = EXCLUDED.hash, parent_hash = EXCLUDED.parent_hash`,
+		cp.ChainID, cp.BlockNumber, cp.BlockHash, cp.ParentHash)
+	if err != nil {
+		return err
+	}
+	if err = upsertCheckpoint(ctx, tx, cp); err != nil {
+		return err
+	}
+	if keep > 0 && cp.BlockNumber > keep {
+		_, err = tx.ExecContext(ctx, `DELETE FROM scanned_blocks WHERE chain_id = $1 AND number < $2`, cp.ChainID, cp.BlockNumber-keep)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ScannedBlockHash returns the hash recorded for a chain's scanned block number.
+func (s *Store) ScannedBlockHash(ctx context.Context, chainID uint64, number uint64) (string, bool, error) {
+	var hash string
+	err := s.db.QueryRowContext(ctx, `SELECT hash FROM scanned_blocks WHERE chain_id = $1 AND number = $2`, chainID, number).Scan(&hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return hash, true, nil
+}
+
+// RewindCheckpoint moves the checkpoint back to a common ancestor after a reorg and forgets
+// the scanned blocks above it.
+func (s *Store) RewindCheckpoint(ctx context.Context, cp models.Checkpoint) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM scanned_blocks WHERE chain_id = $1 AND number > $2`, cp.ChainID, cp.BlockNumber)
+	if err != nil {
+		return err
+	}
+	if err = upsertCheckpoint(ctx, tx, cp); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func upsertCheckpoint(ctx context.Context, ex execer, cp models.Checkpoint) error {
+	_, err := ex.ExecContext(ctx, `INSERT INTO scan_checkpoints(chain_id, name, block_number, block_hash, updated_at) VALUES($1, $2, $3, $4, now()) ON CONFLICT (chain_id, name) DO UPDATE SET block_number = EXCLUDED.block_number, block_hash = EXCLUDED.block_hash, updated_at = now()`,
+		cp.ChainID, cp.Name, cp.BlockNumber, cp.BlockHash)
+	return err
+}
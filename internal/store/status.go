@@ -0,0 +1,50 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/namtran/creditengine/internal/models"
+)
+
+var (
+	ErrDepositNotFound = errors.New("deposit not found")
+	// ErrInvalidTransition is returned when a deposit's current status does not allow the
+	// requested one (see models.DepositStatus.CanTransition).
+	ErrInvalidTransition = errors.New("invalid status transition")
+)
+
+// transition moves deposit id to status to inside tx, after locking the row and checking
+// the move against the allowed transitions, and records it in deposit_status_history. It
+// returns the status the deposit was in, also when the transition is refused.
+func transition(ctx context.Context, tx *sql.Tx, id int64, to models.DepositStatus, reason string) (models.DepositStatus, error) {
+	var from models.DepositStatus
+	var chainID uint64
+	var txBlock sql.NullInt64
+	var blockHash sql.NullString
+	err := tx.QueryRowContext(ctx, `SELECT status, chain_id, tx_block, block_hash FROM deposits WHERE id = $1 FOR UPDATE`, id).Scan(&from, &chainID, &txBlock, &blockHash)
+	if err == sql.ErrNoRows {
+		return "", ErrDepositNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if !from.CanTransition(to) {
+		return from, fmt.Errorf("deposit %d: %s to %s: %w", id, from, to, ErrInvalidTransition)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE deposits SET status = $1 WHERE id = $2`, to, id); err != nil {
+		return from, err
+	}
+	return from, recordTransition(ctx, tx, id, from, to, reason, chainID, txBlock, blockHash)
+}
+
+// recordTransition writes one row of deposit_status_history. The chain context is the
+// deposit's chain and block at the time of the transition.
+func recordTransition(ctx context.Context, ex execer, id int64, from, to models.DepositStatus, reason string, chainID uint64, txBlock sql.NullInt64, blockHash sql.NullString) error {
+	_, err := ex.ExecContext(ctx, `INSERT INTO deposit_status_history(deposit_id, from_status, to_status, reason, chain_id, tx_block, block_hash, created_at) VALUES($1, $2, $3, $4, $5, $6, $7, $8)`,
+		id, from, to, reason, chainID, txBlock, blockHash, time.Now())
+	return err
+}
@@ -0,0 +1,86 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/namtran/creditengine/internal/models"
+	"github.com/namtran/creditengine/internal/store"
+)
+
+var lockQuery = regexp.QuoteMeta("SELECT status, chain_id, tx_block, block_hash FROM deposits WHERE id = $1 FOR UPDATE")
+
+func lockedRow(status string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"status", "chain_id", "tx_block", "block_hash"}).AddRow(status, 1, 90, "0xhash")
+}
+
+func TestCreditIfNotCredited_AlreadyCredited(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(lockedRow("credited"))
+	mock.ExpectRollback()
+
+	d := models.Deposit{ID: 1, TxHash: "0xabc", Address: "0xaddr", Amount: models.Int64Amount(1000)}
+	if err := store.New(db).CreditIfNotCredited(context.Background(), d, nil); !errors.Is(err, store.ErrAlreadyCredited) {
+		t.Fatalf("expected ErrAlreadyCredited, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+// A deposit in a terminal status is never moved again, and nothing is written.
+func TestMarkDepositReorged_RefusesTerminalStatus(t *testing.T) {
+	for _, status := range []string{"failed", "mismatch", "reversed"} {
+		t.Run(status, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to create sqlmock: %v", err)
+			}
+			defer func() { _ = db.Close() }()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(lockedRow(status))
+			mock.ExpectRollback()
+
+			err = store.New(db).MarkDepositReorged(context.Background(), 1, "receipt missing")
+			if !errors.Is(err, store.ErrInvalidTransition) {
+				t.Fatalf("expected ErrInvalidTransition, got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("unmet expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestMarkDepositReincluded_NotReorged(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	// the deposit was re-included by another pass in the meantime
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET tx_block = $1, block_hash = $2, confirmations = $3, reorged_at = NULL WHERE id = $4")).
+		WithArgs(97, "0xb97", 1, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(lockedRow("pending"))
+	mock.ExpectRollback()
+
+	ok, err := store.New(db).MarkDepositReincluded(context.Background(), 1, models.Reinclusion{NewTxBlock: 97, NewBlockHash: "0xb97", Confirmations: 1})
+	if err != nil || ok {
+		t.Fatalf("expected (false, nil), got (%v, %v)", ok, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -3,8 +3,11 @@ package store
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/namtran/creditengine/internal/models"
@@ -16,22 +19,43 @@ type Store struct {
 
 func New(db *sql.DB) *Store { return &Store{db: db} }
 
-// GetPendingDeposits returns deposits that are not yet credited
-func (s *Store) GetPendingDeposits(ctx context.Context) ([]models.Deposit, error) {
-	rows, err := s.db.QueryContext(ctx, `SELECT id, tx_hash, address, amount, confirmations, tx_block, block_hash, status, received_at FROM deposits WHERE status = 'pending'`)
-	if err != nil {
-		return nil, err
-	}
-	defer func() { _ = rows.Close() }()
+// depositColumns is the column list scanDeposits expects, in order.
+const depositColumns = `id, chain_id, tx_hash, log_index, trace_index, address, token, amount, confirmations, tx_block, block_hash, status, received_at, needs_flush, recipient`
+
+func scanDeposits(rows *sql.Rows) ([]models.Deposit, error) {
 	var res []models.Deposit
 	for rows.Next() {
 		var d models.Deposit
-		if err := rows.Scan(&d.ID, &d.TxHash, &d.Address, &d.Amount, &d.Confirmations, &d.TxBlock, &d.BlockHash, &d.Status, &d.ReceivedAt); err != nil {
+		if err := rows.Scan(&d.ID, &d.ChainID, &d.TxHash, &d.LogIndex, &d.TraceIndex, &d.Address, &d.Token, &d.Amount, &d.Confirmations, &d.TxBlock, &d.BlockHash, &d.Status, &d.ReceivedAt, &d.NeedsFlush, &d.Recipient); err != nil {
 			return nil, err
 		}
 		res = append(res, d)
 	}
-	return res, nil
+	return res, rows.Err()
+}
+
+// GetPendingDeposits returns a chain's deposits that are not yet credited
+func (s *Store) GetPendingDeposits(ctx context.Context, chainID uint64) ([]models.Deposit, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+depositColumns+` FROM deposits WHERE chain_id = $1 AND status = 'pending'`, chainID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	return scanDeposits(rows)
+}
+
+// GetUnsettledDeposits returns a chain's pending deposits together with the ones still
+// being watched: credited deposits with fewer than watchUntil confirmations recorded, and
+// deposits reorged after reorgedSince, which may yet be re-included. A watchUntil of 0
+// leaves credited deposits out. Deposits come in the order they were recorded, which is
+// the order each address's deposits are processed in.
+func (s *Store) GetUnsettledDeposits(ctx context.Context, chainID, watchUntil uint64, reorgedSince time.Time) ([]models.Deposit, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+depositColumns+` FROM deposits WHERE chain_id = $1 AND (status = 'pending' OR (status = 'credited' AND confirmations < $2) OR (status = 'reorged' AND reorged_at > $3)) ORDER BY id`, chainID, watchUntil, reorgedSince)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	return scanDeposits(rows)
 }
 
 // UpdateDepositConfirmations updates the confirmations column for a deposit
@@ -46,39 +70,227 @@ func (s *Store) UpdateDepositTxInfo(ctx context.Context, id int64, txBlock uint6
 	return err
 }
 
-// MarkDepositReorged marks a deposit as reorged when its receipt disappears or block hash mismatches
-func (s *Store) MarkDepositReorged(ctx context.Context, id int64) error {
-	_, err := s.db.ExecContext(ctx, `UPDATE deposits SET status = 'reorged' WHERE id = $1`, id)
+// MarkDepositReorged marks a pending deposit as reorged when its receipt disappears or
+// block hash mismatches. reason is recorded in the status history.
+func (s *Store) MarkDepositReorged(ctx context.Context, id int64, reason string) error {
+	return s.markDeposit(ctx, id, models.StatusReorged, reason, "reorged", nil)
+}
+
+// MarkDepositFailed marks a pending deposit whose transaction was mined but reverted.
+func (s *Store) MarkDepositFailed(ctx context.Context, id int64) error {
+	return s.markDeposit(ctx, id, models.StatusFailed, "transaction reverted", "failed", nil)
+}
+
+// MarkDepositMismatch marks a pending deposit whose row disagrees with the chain, so it is
+// never credited, and audits the chain's evidence.
+func (s *Store) MarkDepositMismatch(ctx context.Context, id int64, ev models.MismatchEvidence) error {
+	details, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return s.markDeposit(ctx, id, models.StatusMismatch, "chain disagrees: "+ev.Reason, "mismatch", details)
+}
+
+// markDeposit moves a deposit to status to and writes an audit with action and details
+// (nil for none), in one transaction. A reorged deposit is re-checked for re-inclusion
+// from now on.
+func (s *Store) markDeposit(ctx context.Context, id int64, to models.DepositStatus, reason, action string, details []byte) error {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	_, err = s.db.ExecContext(ctx, `INSERT INTO audits(deposit_id, action, created_at) VALUES($1, $2, $3)`, id, "reorged", time.Now())
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = transition(ctx, tx, id, to, reason); err != nil {
+		return err
+	}
+	if to == models.StatusReorged {
+		if _, err = tx.ExecContext(ctx, `UPDATE deposits SET reorged_at = $1 WHERE id = $2`, time.Now(), id); err != nil {
+			return err
+		}
+	}
+	if details == nil {
+		_, err = tx.ExecContext(ctx, `INSERT INTO audits(deposit_id, action, created_at) VALUES($1, $2, $3)`, id, action, time.Now())
+	} else {
+		_, err = tx.ExecContext(ctx, `INSERT INTO audits(deposit_id, action, details, created_at) VALUES($1, $2, $3, $4)`, id, action, string(details), time.Now())
+	}
 	if err != nil {
 		log.Printf("failed to write audit: %v", err)
 	}
-	return nil
+	return tx.Commit()
+}
+
+// MarkDepositReincluded moves a reorged deposit back to pending at the block its
+// transaction was re-mined in, and audits the move. It reports false if the deposit was
+// no longer reorged.
+func (s *Store) MarkDepositReincluded(ctx context.Context, id int64, ev models.Reinclusion) (bool, error) {
+	details, err := json.Marshal(ev)
+	if err != nil {
+		return false, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// the new block goes in first, so the status history records it
+	_, err = tx.ExecContext(ctx, `UPDATE deposits SET tx_block = $1, block_hash = $2, confirmations = $3, reorged_at = NULL WHERE id = $4`,
+		ev.NewTxBlock, ev.NewBlockHash, ev.Confirmations, id)
+	if err != nil {
+		return false, err
+	}
+	if _, err = transition(ctx, tx, id, models.StatusPending, fmt.Sprintf("re-included at block %d", ev.NewTxBlock)); err != nil {
+		if errors.Is(err, ErrInvalidTransition) {
+			return false, nil
+		}
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO audits(deposit_id, action, details, created_at) VALUES($1, $2, $3, $4)`, id, "reincluded", string(details), time.Now())
+	if err != nil {
+		log.Printf("failed to write audit: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RecordReorg records a detected reorg and marks every pending deposit in the orphaned
+// block range as reorged, in one transaction. It returns the ids of the affected deposits.
+func (s *Store) RecordReorg(ctx context.Context, r models.Reorg) ([]int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO reorgs(chain_id, from_block, to_block, depth, orphaned_head_hash, detected_at) VALUES($1, $2, $3, $4, $5, $6)`,
+		r.ChainID, r.FromBlock, r.ToBlock, r.Depth, r.OrphanedHeadHash, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	// pending to reorged is always allowed, so the range is moved in one statement
+	rows, err := tx.QueryContext(ctx, `UPDATE deposits SET status = 'reorged', reorged_at = $4 WHERE chain_id = $1 AND status = 'pending' AND tx_block BETWEEN $2 AND $3 RETURNING id, tx_block, block_hash`, r.ChainID, r.FromBlock, r.ToBlock, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	type moved struct {
+		id        int64
+		txBlock   sql.NullInt64
+		blockHash sql.NullString
+	}
+	var affected []moved
+	for rows.Next() {
+		var m moved
+		if err = rows.Scan(&m.id, &m.txBlock, &m.blockHash); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		affected = append(affected, m)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	reason := fmt.Sprintf("reorg of depth %d orphaned blocks %d-%d", r.Depth, r.FromBlock, r.ToBlock)
+	ids := make([]int64, 0, len(affected))
+	for _, m := range affected {
+		if err = recordTransition(ctx, tx, m.id, models.StatusPending, models.StatusReorged, reason, r.ChainID, m.txBlock, m.blockHash); err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO audits(deposit_id, action, created_at) VALUES($1, $2, $3)`, m.id, "reorged", time.Now())
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, m.id)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return ids, nil
 }
 
 // ListDeposits returns deposits (optionally all statuses)
 func (s *Store) ListDeposits(ctx context.Context) ([]models.Deposit, error) {
-	rows, err := s.db.QueryContext(ctx, `SELECT id, tx_hash, address, amount, confirmations, tx_block, block_hash, status, received_at FROM deposits ORDER BY received_at DESC`)
+	rows, err := s.db.QueryContext(ctx, `SELECT `+depositColumns+` FROM deposits ORDER BY received_at DESC`)
 	if err != nil {
 		return nil, err
 	}
 	defer func() { _ = rows.Close() }()
-	var res []models.Deposit
+	return scanDeposits(rows)
+}
+
+// AccountAddresses returns a chain's account addresses keyed by their lower-cased form,
+// so chain addresses can be matched regardless of checksum casing.
+func (s *Store) AccountAddresses(ctx context.Context, chainID uint64) (map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT address FROM accounts WHERE chain_id = $1`, chainID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	res := make(map[string]string)
 	for rows.Next() {
-		var d models.Deposit
-		if err := rows.Scan(&d.ID, &d.TxHash, &d.Address, &d.Amount, &d.Confirmations, &d.TxBlock, &d.BlockHash, &d.Status, &d.ReceivedAt); err != nil {
+		var addr string
+		if err := rows.Scan(&addr); err != nil {
 			return nil, err
 		}
-		res = append(res, d)
+		res[strings.ToLower(addr)] = addr
 	}
-	return res, nil
+	return res, rows.Err()
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertDeposit records a newly discovered deposit as pending. Deposits that were already
+// recorded are left untouched, so rescanning a block is harmless.
+func insertDeposit(ctx context.Context, ex execer, d models.Deposit) error {
+	_, err := ex.ExecContext(ctx, `INSERT INTO deposits(chain_id, tx_hash, log_index, trace_index, address, token, amount, tx_block, block_hash, needs_flush, recipient, status) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, 'pending') ON CONFLICT (chain_id, tx_hash, log_index, trace_index) DO NOTHING`,
+		d.ChainID, d.TxHash, d.LogIndex, d.TraceIndex, d.Address, d.Token, d.Amount, d.TxBlock, d.BlockHash, d.NeedsFlush, d.Recipient)
+	return err
 }
 
 // ReverseCredit attempts to reverse a previously credited deposit (for demo/test only)
 func (s *Store) ReverseCredit(ctx context.Context, depositID int64) error {
+	return s.reverseCredit(ctx, depositID, models.StatusReversed, "manual reversal", "reversed", nil)
+}
+
+// ReverseReorgedCredit takes back a credited deposit whose transaction was reorged out
+// or moved to another block. The deposit goes back to reorged (or to failed, if the
+// transaction now reverts) and the audit records ev.
+func (s *Store) ReverseReorgedCredit(ctx context.Context, depositID int64, ev models.ReorgReversal) error {
+	details, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	to := models.StatusReorged
+	if ev.Reason == "reverted" {
+		to = models.StatusFailed
+	}
+	return s.reverseCredit(ctx, depositID, to, "credit reversed: "+ev.Reason, "reorg_reversed", details)
+}
+
+// reverseCredit debits a credited deposit's amount, moves the deposit to status to and
+// writes an audit with action and details, in one transaction.
+func (s *Store) reverseCredit(ctx context.Context, depositID int64, to models.DepositStatus, reason, action string, details []byte) error {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
@@ -89,30 +301,47 @@ func (s *Store) ReverseCredit(ctx context.Context, depositID int64) error {
 		}
 	}()
 
-	// find deposit and status
-	var addr string
-	var amount int64
-	var status string
-	err = tx.QueryRowContext(ctx, `SELECT address, amount, status FROM deposits WHERE id = $1 FOR UPDATE`, depositID).Scan(&addr, &amount, &status)
+	var from models.DepositStatus
+	from, err = transition(ctx, tx, depositID, to, reason)
 	if err != nil {
+		if errors.Is(err, ErrInvalidTransition) && from != models.StatusCredited {
+			err = errors.New("deposit not credited")
+		}
 		return err
 	}
-	if status != "credited" {
-		return errors.New("deposit not credited")
-	}
 
-	// decrement account balance (simple demo)
-	_, err = tx.ExecContext(ctx, `UPDATE accounts SET balance = balance - $1 WHERE address = $2`, amount, addr)
+	// find the credited amount
+	var chainID uint64
+	var addr string
+	var token sql.NullString
+	var amount models.Amount
+	err = tx.QueryRowContext(ctx, `SELECT chain_id, address, token, amount FROM deposits WHERE id = $1`, depositID).Scan(&chainID, &addr, &token, &amount)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.ExecContext(ctx, `UPDATE deposits SET status = 'reversed' WHERE id = $1`, depositID)
+	// decrement account balance (simple demo)
+	if token.Valid {
+		_, err = tx.ExecContext(ctx, `UPDATE token_balances SET balance = balance - $1 WHERE chain_id = $2 AND address = $3 AND token = $4`, amount, chainID, addr, token.String)
+	} else {
+		_, err = tx.ExecContext(ctx, `UPDATE accounts SET balance = balance - $1 WHERE chain_id = $2 AND address = $3`, amount, chainID, addr)
+	}
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.ExecContext(ctx, `INSERT INTO audits(deposit_id, action, created_at) VALUES($1, $2, $3)`, depositID, "reversed", time.Now())
+	// a reorged deposit is re-checked for re-inclusion from now on
+	if to == models.StatusReorged {
+		if _, err = tx.ExecContext(ctx, `UPDATE deposits SET reorged_at = $1 WHERE id = $2`, time.Now(), depositID); err != nil {
+			return err
+		}
+	}
+
+	if details == nil {
+		_, err = tx.ExecContext(ctx, `INSERT INTO audits(deposit_id, action, created_at) VALUES($1, $2, $3)`, depositID, action, time.Now())
+	} else {
+		_, err = tx.ExecContext(ctx, `INSERT INTO audits(deposit_id, action, details, created_at) VALUES($1, $2, $3, $4)`, depositID, action, string(details), time.Now())
+	}
 	if err != nil {
 		log.Printf("failed to write audit: %v", err)
 	}
@@ -123,10 +352,27 @@ func (s *Store) ReverseCredit(ctx context.Context, depositID int64) error {
 	return nil
 }
 
+// nullJSON maps empty details to a NULL jsonb value.
+func nullJSON(b []byte) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+	return string(b)
+}
+
 var ErrAlreadyCredited = errors.New("already credited")
 
 // CreditIfNotCredited performs idempotent credit: only credits if deposit not previously credited.
-func (s *Store) CreditIfNotCredited(ctx context.Context, d models.Deposit) error {
+// The finality evidence, if any, is stored with the audit record.
+func (s *Store) CreditIfNotCredited(ctx context.Context, d models.Deposit, ev *models.FinalityEvidence) error {
+	var details []byte
+	if ev != nil {
+		var err error
+		if details, err = json.Marshal(ev); err != nil {
+			return err
+		}
+	}
+
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
@@ -137,32 +383,37 @@ func (s *Store) CreditIfNotCredited(ctx context.Context, d models.Deposit) error
 		}
 	}()
 
-	// check deposit status
-	var status string
-	err = tx.QueryRowContext(ctx, `SELECT status FROM deposits WHERE id = $1 FOR UPDATE`, d.ID).Scan(&status)
-	if err == sql.ErrNoRows {
-		return errors.New("deposit not found")
+	// move the deposit to credited; only a pending deposit may be credited
+	reason := "final"
+	if ev != nil {
+		reason = "final by " + ev.Mode
+	}
+	var from models.DepositStatus
+	from, err = transition(ctx, tx, d.ID, models.StatusCredited, reason)
+	if from == models.StatusCredited {
+		err = ErrAlreadyCredited
 	}
 	if err != nil {
 		return err
 	}
-	if status == "credited" {
-		return ErrAlreadyCredited
-	}
 
-	// update account balance
-	_, err = tx.ExecContext(ctx, `UPDATE accounts SET balance = balance + $1 WHERE address = $2`, d.Amount, d.Address)
+	// update account balance; token deposits are credited to the per-token balance
+	if d.Token.Valid {
+		_, err = tx.ExecContext(ctx, `INSERT INTO token_balances(chain_id, address, token, balance) VALUES($1, $2, $3, $4) ON CONFLICT (chain_id, address, token) DO UPDATE SET balance = token_balances.balance + EXCLUDED.balance`, d.ChainID, d.Address, d.Token.String, d.Amount)
+	} else {
+		_, err = tx.ExecContext(ctx, `UPDATE accounts SET balance = balance + $1 WHERE chain_id = $2 AND address = $3`, d.Amount, d.ChainID, d.Address)
+	}
 	if err != nil {
 		return err
 	}
 
-	// mark deposit credited and write audit
-	_, err = tx.ExecContext(ctx, `UPDATE deposits SET status = 'credited', credited_at = $1 WHERE id = $2`, time.Now(), d.ID)
+	// stamp the credit and write audit
+	_, err = tx.ExecContext(ctx, `UPDATE deposits SET credited_at = $1 WHERE id = $2`, time.Now(), d.ID)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.ExecContext(ctx, `INSERT INTO audits(deposit_id, action, created_at) VALUES($1, $2, $3)`, d.ID, "credited", time.Now())
+	_, err = tx.ExecContext(ctx, `INSERT INTO audits(deposit_id, action, details, created_at) VALUES($1, $2, $3, $4)`, d.ID, "credited", nullJSON(details), time.Now())
 	if err != nil {
 		log.Printf("failed to write audit: %v", err)
 	}
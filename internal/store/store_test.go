@@ -24,21 +24,24 @@ func TestCreditIfNotCredited_Idempotent(t *testing.T) {
 
 	// begin
 	mock.ExpectBegin()
-	// select status
-	rows := sqlmock.NewRows([]string{"status"}).AddRow("pending")
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT status FROM deposits WHERE id = $1 FOR UPDATE")).WillReturnRows(rows)
+	// lock the deposit and move it to credited
+	rows := sqlmock.NewRows([]string{"status", "chain_id", "tx_block", "block_hash"}).AddRow("pending", 1, 90, "0xhash")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT status, chain_id, tx_block, block_hash FROM deposits WHERE id = $1 FOR UPDATE")).WillReturnRows(rows)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET status = $1 WHERE id = $2")).WithArgs("credited", 1).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO deposit_status_history(deposit_id, from_status, to_status, reason, chain_id, tx_block, block_hash, created_at) VALUES($1, $2, $3, $4, $5, $6, $7, $8)")).
+		WithArgs(1, "pending", "credited", "final", 1, 90, "0xhash", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
 	// update accounts
-	mock.ExpectExec(regexp.QuoteMeta("UPDATE accounts SET balance = balance + $1 WHERE address = $2")).WillReturnResult(sqlmock.NewResult(1, 1))
-	// update deposit
-	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET status = 'credited', credited_at = $1 WHERE id = $2")).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE accounts SET balance = balance + $1 WHERE chain_id = $2 AND address = $3")).WillReturnResult(sqlmock.NewResult(1, 1))
+	// stamp the credit
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE deposits SET credited_at = $1 WHERE id = $2")).WillReturnResult(sqlmock.NewResult(1, 1))
 	// insert audit
-	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audits(deposit_id, action, created_at) VALUES($1, $2, $3)")).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audits(deposit_id, action, details, created_at) VALUES($1, $2, $3, $4)")).WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectCommit()
 
-	d := models.Deposit{ID: 1, TxHash: "0xabc", Address: "0xaddr", Amount: 1000, Confirmations: 12}
+	d := models.Deposit{ID: 1, TxHash: "0xabc", Address: "0xaddr", Amount: models.Int64Amount(1000), Confirmations: 12}
 
 	// call through
-	if err := s.CreditIfNotCredited(ctx, d); err != nil {
+	if err := s.CreditIfNotCredited(ctx, d, nil); err != nil {
 		t.Fatalf("credit failed: %v", err)
 	}
 
@@ -58,10 +61,10 @@ func TestGetPendingDeposits_Nulls(t *testing.T) {
 
 	// prepare rows with NULL tx_block and NULL block_hash
 	ts, _ := time.Parse("2006-01-02 15:04:05", "2025-12-21 00:00:00")
-	rows := sqlmock.NewRows([]string{"id", "tx_hash", "address", "amount", "confirmations", "tx_block", "block_hash", "status", "received_at"}).AddRow(1, "0xabc", "0xaddr", 1000, 0, nil, nil, "pending", ts)
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, tx_hash, address, amount, confirmations, tx_block, block_hash, status, received_at FROM deposits WHERE status = 'pending'")).WillReturnRows(rows)
+	rows := sqlmock.NewRows([]string{"id", "chain_id", "tx_hash", "log_index", "trace_index", "address", "token", "amount", "confirmations", "tx_block", "block_hash", "status", "received_at", "needs_flush", "recipient"}).AddRow(1, 1, "0xabc", -1, 0, "0xaddr", nil, 1000, 0, nil, nil, "pending", ts, false, nil)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, chain_id, tx_hash, log_index, trace_index, address, token, amount, confirmations, tx_block, block_hash, status, received_at, needs_flush, recipient FROM deposits WHERE chain_id = $1 AND status = 'pending'")).WillReturnRows(rows)
 
-	deps, err := s.GetPendingDeposits(context.Background())
+	deps, err := s.GetPendingDeposits(context.Background(), 1)
 	if err != nil {
 		t.Fatalf("GetPendingDeposits error: %v", err)
 	}
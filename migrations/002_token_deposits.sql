@@ -0,0 +1,26 @@
+-- ERC-20 deposits: a deposit is identified by (tx_hash, log_index) so one transaction
+-- can pay several accounts. Native transfers have no log and use log_index = -1.
+ALTER TABLE deposits ADD COLUMN IF NOT EXISTS log_index integer not null default -1;
+ALTER TABLE deposits ADD COLUMN IF NOT EXISTS token text;
+
+ALTER TABLE deposits DROP CONSTRAINT IF EXISTS deposits_tx_hash_key;
+-- guarded so the file can be re-applied; later migrations replace this key with wider
+-- ones, and once they have run it must not come back
+DO $$
+BEGIN
+  IF NOT EXISTS (
+    SELECT 1 FROM pg_constraint
+    WHERE conrelid = 'deposits'::regclass
+      AND conname IN ('deposits_tx_hash_log_index_key', 'deposits_chain_id_tx_hash_log_index_key', 'deposits_identity_key')
+  ) THEN
+    ALTER TABLE deposits ADD CONSTRAINT deposits_tx_hash_log_index_key UNIQUE (tx_hash, log_index);
+  END IF;
+END $$;
+
+-- token balances live beside the native balance in accounts.balance
+CREATE TABLE IF NOT EXISTS token_balances (
+  address text not null references accounts(address),
+  token text not null,
+  balance bigint not null default 0,
+  primary key (address, token)
+);
@@ -0,0 +1,14 @@
+-- scanner checkpoint: the last block whose deposits have been recorded
+CREATE TABLE IF NOT EXISTS scan_checkpoints (
+  name text primary key,
+  block_number bigint not null,
+  block_hash text not null,
+  updated_at timestamptz not null default now()
+);
+
+-- recent scanned block hashes, used to find the common ancestor after a reorg
+CREATE TABLE IF NOT EXISTS scanned_blocks (
+  number bigint primary key,
+  hash text not null,
+  parent_hash text not null
+);
@@ -0,0 +1,11 @@
+-- reorgs detected by the header tracker, with the orphaned block range
+CREATE TABLE IF NOT EXISTS reorgs (
+  id bigserial primary key,
+  from_block bigint not null,
+  to_block bigint not null,
+  depth bigint not null,
+  orphaned_head_hash text not null,
+  detected_at timestamptz not null default now()
+);
+
+CREATE INDEX IF NOT EXISTS deposits_tx_block_idx ON deposits(tx_block);
@@ -0,0 +1,2 @@
+-- structured context for audits, e.g. the finality evidence behind a credit
+ALTER TABLE audits ADD COLUMN IF NOT EXISTS details jsonb;
@@ -0,0 +1,81 @@
+-- multi-chain: deposits, accounts, balances and scanner state are scoped by chain_id.
+-- Existing rows belong to Ethereum mainnet (1). Keys are added in guarded blocks so the
+-- file can be re-applied.
+ALTER TABLE deposits ADD COLUMN IF NOT EXISTS chain_id bigint not null default 1;
+ALTER TABLE deposits DROP CONSTRAINT IF EXISTS deposits_tx_hash_log_index_key;
+-- 007 replaces this key with deposits_identity_key; once it has, leave it out
+DO $$
+BEGIN
+  IF NOT EXISTS (
+    SELECT 1 FROM pg_constraint
+    WHERE conrelid = 'deposits'::regclass
+      AND conname IN ('deposits_chain_id_tx_hash_log_index_key', 'deposits_identity_key')
+  ) THEN
+    ALTER TABLE deposits ADD CONSTRAINT deposits_chain_id_tx_hash_log_index_key UNIQUE (chain_id, tx_hash, log_index);
+  END IF;
+END $$;
+
+-- an address is an account per chain, with its own native balance there
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS chain_id bigint not null default 1;
+ALTER TABLE token_balances ADD COLUMN IF NOT EXISTS chain_id bigint not null default 1;
+ALTER TABLE token_balances DROP CONSTRAINT IF EXISTS token_balances_address_fkey;
+-- the old primary keys share their name with the new ones, so they are replaced only
+-- while they don't include chain_id yet
+DO $$
+BEGIN
+  IF NOT EXISTS (
+    SELECT 1 FROM pg_constraint c
+    JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = ANY (c.conkey)
+    WHERE c.conrelid = 'token_balances'::regclass AND c.contype = 'p' AND a.attname = 'chain_id'
+  ) THEN
+    ALTER TABLE token_balances DROP CONSTRAINT IF EXISTS token_balances_pkey;
+    ALTER TABLE token_balances ADD PRIMARY KEY (chain_id, address, token);
+  END IF;
+END $$;
+ALTER TABLE accounts DROP CONSTRAINT IF EXISTS accounts_address_key;
+DO $$
+BEGIN
+  IF NOT EXISTS (
+    SELECT 1 FROM pg_constraint
+    WHERE conrelid = 'accounts'::regclass AND conname = 'accounts_chain_id_address_key'
+  ) THEN
+    ALTER TABLE accounts ADD CONSTRAINT accounts_chain_id_address_key UNIQUE (chain_id, address);
+  END IF;
+  IF NOT EXISTS (
+    SELECT 1 FROM pg_constraint
+    WHERE conrelid = 'token_balances'::regclass AND conname = 'token_balances_account_fkey'
+  ) THEN
+    ALTER TABLE token_balances ADD CONSTRAINT token_balances_account_fkey FOREIGN KEY (chain_id, address) REFERENCES accounts(chain_id, address);
+  END IF;
+END $$;
+
+ALTER TABLE scan_checkpoints ADD COLUMN IF NOT EXISTS chain_id bigint not null default 1;
+DO $$
+BEGIN
+  IF NOT EXISTS (
+    SELECT 1 FROM pg_constraint c
+    JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = ANY (c.conkey)
+    WHERE c.conrelid = 'scan_checkpoints'::regclass AND c.contype = 'p' AND a.attname = 'chain_id'
+  ) THEN
+    ALTER TABLE scan_checkpoints DROP CONSTRAINT IF EXISTS scan_checkpoints_pkey;
+    ALTER TABLE scan_checkpoints ADD PRIMARY KEY (chain_id, name);
+  END IF;
+END $$;
+
+ALTER TABLE scanned_blocks ADD COLUMN IF NOT EXISTS chain_id bigint not null default 1;
+DO $$
+BEGIN
+  IF NOT EXISTS (
+    SELECT 1 FROM pg_constraint c
+    JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = ANY (c.conkey)
+    WHERE c.conrelid = 'scanned_blocks'::regclass AND c.contype = 'p' AND a.attname = 'chain_id'
+  ) THEN
+    ALTER TABLE scanned_blocks DROP CONSTRAINT IF EXISTS scanned_blocks_pkey;
+    ALTER TABLE scanned_blocks ADD PRIMARY KEY (chain_id, number);
+  END IF;
+END $$;
+
+ALTER TABLE reorgs ADD COLUMN IF NOT EXISTS chain_id bigint not null default 1;
+
+DROP INDEX IF EXISTS deposits_tx_block_idx;
+CREATE INDEX IF NOT EXISTS deposits_chain_id_tx_block_idx ON deposits(chain_id, tx_block);
@@ -0,0 +1,14 @@
+-- internal (contract-originated) ETH transfers: identified by the call frame's position
+-- in the transaction's trace. Top-level transfers and token logs use trace_index = 0.
+ALTER TABLE deposits ADD COLUMN IF NOT EXISTS trace_index integer not null default 0;
+
+ALTER TABLE deposits DROP CONSTRAINT IF EXISTS deposits_chain_id_tx_hash_log_index_key;
+DO $$
+BEGIN
+  IF NOT EXISTS (
+    SELECT 1 FROM pg_constraint
+    WHERE conrelid = 'deposits'::regclass AND conname = 'deposits_identity_key'
+  ) THEN
+    ALTER TABLE deposits ADD CONSTRAINT deposits_identity_key UNIQUE (chain_id, tx_hash, log_index, trace_index);
+  END IF;
+END $$;
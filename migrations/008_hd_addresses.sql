@@ -0,0 +1,9 @@
+-- HD deposit addresses: accounts allocated from the configured xpub record their
+-- derivation index; hd_counters hands out the next index per chain.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS derivation_index bigint;
+CREATE UNIQUE INDEX IF NOT EXISTS accounts_chain_id_derivation_index_key ON accounts(chain_id, derivation_index);
+
+CREATE TABLE IF NOT EXISTS hd_counters (
+  chain_id bigint primary key,
+  next_index bigint not null
+);
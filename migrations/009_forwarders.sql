@@ -0,0 +1,7 @@
+-- CREATE2 forwarders: an account's counterfactual forwarder address, and a flag on
+-- deposits that landed at a forwarder and still need it deployed and flushed.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS forwarder_address text;
+CREATE UNIQUE INDEX IF NOT EXISTS accounts_chain_id_forwarder_address_key ON accounts(chain_id, forwarder_address);
+
+ALTER TABLE deposits ADD COLUMN IF NOT EXISTS needs_flush boolean not null default false;
+CREATE INDEX IF NOT EXISTS deposits_needs_flush_idx ON deposits(chain_id) WHERE needs_flush;
@@ -0,0 +1,2 @@
+-- credited deposits are re-checked until they pass the watch window; keep that lookup cheap
+CREATE INDEX IF NOT EXISTS deposits_credited_watch_idx ON deposits(chain_id, confirmations) WHERE status = 'credited';
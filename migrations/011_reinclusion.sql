@@ -0,0 +1,3 @@
+-- reorged deposits are re-checked for re-inclusion for a while after they were reorged
+ALTER TABLE deposits ADD COLUMN IF NOT EXISTS reorged_at timestamptz;
+CREATE INDEX IF NOT EXISTS deposits_reorged_at_idx ON deposits(chain_id, reorged_at) WHERE status = 'reorged';
@@ -0,0 +1,19 @@
+-- deposit statuses are a closed set; the store only moves deposits along allowed transitions
+ALTER TABLE deposits DROP CONSTRAINT IF EXISTS deposits_status_check;
+ALTER TABLE deposits ADD CONSTRAINT deposits_status_check
+  CHECK (status IN ('pending', 'credited', 'reorged', 'failed', 'mismatch', 'reversed'));
+
+-- every status change, with why it happened and the block the deposit was in at the time
+CREATE TABLE IF NOT EXISTS deposit_status_history (
+  id bigserial primary key,
+  deposit_id bigint not null references deposits(id),
+  from_status text not null,
+  to_status text not null,
+  reason text not null,
+  chain_id bigint not null,
+  tx_block bigint,
+  block_hash text,
+  created_at timestamptz not null default now()
+);
+
+CREATE INDEX IF NOT EXISTS deposit_status_history_deposit_idx ON deposit_status_history(deposit_id, created_at);
@@ -0,0 +1,4 @@
+-- amounts are in base units (wei) and routinely exceed bigint; numeric(78,0) holds any uint256
+ALTER TABLE deposits ALTER COLUMN amount TYPE numeric(78,0);
+ALTER TABLE accounts ALTER COLUMN balance TYPE numeric(78,0);
+ALTER TABLE token_balances ALTER COLUMN balance TYPE numeric(78,0);
@@ -0,0 +1,7 @@
+-- the address the chain paid when it is not the account's own (its forwarder). Set once at
+-- discovery, so verification survives needs_flush being cleared and forwarder config
+-- changes. Existing forwarder deposits still awaiting a flush are backfilled.
+ALTER TABLE deposits ADD COLUMN IF NOT EXISTS recipient text;
+UPDATE deposits d SET recipient = a.forwarder_address
+  FROM accounts a
+  WHERE d.needs_flush AND d.recipient IS NULL AND a.chain_id = d.chain_id AND a.address = d.address;
@@ -15,21 +15,23 @@ if ! command -v psql >/dev/null 2>&1; then
 fi
 
 echo "Applying migrations..."
-psql "$DATABASE_URL" -f migrations/001_init.sql
+for f in migrations/*.sql; do
+  psql "$DATABASE_URL" -f "$f"
+done
 
 echo "Seeding example data..."
 psql "$DATABASE_URL" <<'SQL'
 BEGIN;
 
 -- sample account
-INSERT INTO accounts (address, balance) VALUES ('0xaddr', 0) ON CONFLICT (address) DO NOTHING;
+INSERT INTO accounts (chain_id, address, balance) VALUES (1, '0xaddr', 0) ON CONFLICT (chain_id, address) DO NOTHING;
 
 -- sample pending deposits
-INSERT INTO deposits (tx_hash, address, amount, confirmations, status, received_at)
+INSERT INTO deposits (chain_id, tx_hash, address, amount, confirmations, status, received_at)
 VALUES
-  ('0xabc', '0xaddr', 1000, 0, 'pending', now()),
-  ('0xdef', '0xaddr', 2000, 0, 'pending', now())
-ON CONFLICT (tx_hash) DO NOTHING;
+  (1, '0xabc', '0xaddr', 1000, 0, 'pending', now()),
+  (1, '0xdef', '0xaddr', 2000, 0, 'pending', now())
+ON CONFLICT (chain_id, tx_hash, log_index, trace_index) DO NOTHING;
 
 COMMIT;
 SQL